@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/sabershahhoseini/ipset-firewall/error/checkerr"
 	"github.com/sabershahhoseini/ipset-firewall/models"
 	"github.com/sabershahhoseini/ipset-firewall/pkg/ipsetfw"
 	"github.com/sabershahhoseini/ipset-firewall/util/file"
@@ -14,11 +15,12 @@ import (
 func main() {
 
 	// Required flags
-	countryCode := flag.String("country", "", "Specify country code (example: IR)")
+	countryCode := flag.String("country", "", "Specify country code, or a comma separated list of codes and groups (example: IR or ir,cn,EU)")
 	setName := flag.String("set", "", "ipset set name")
-	checkIP := flag.String("check", "", "Check IP exists in pool")
+	checkIP := flag.String("check", "", "Check IP exists in pool, or in the live sets without -country. Accepts a comma separated list")
+	checkFile := flag.String("check-file", "", "Read IPs to check from file, one per line, - reads from stdin")
 	iptablesPolicy := flag.String("policy", "", "iptables policy (accept or drop)")
-	filePath := flag.String("file", "", "Get list from file instead of github")
+	filePath := flag.String("file", "", "Get list from file instead of github, - reads from stdin")
 	iptables := flag.Bool("iptables", false, "Add iptable rules")
 	chain := flag.String("chain", "INPUT", "iptables chain to add rules to")
 	verbose := flag.Bool("v", false, "Verbose mode")
@@ -26,7 +28,16 @@ func main() {
 	clear := flag.Bool("clear", false, "Clear everything")
 	rollback := flag.Bool("rollback", false, "rollback set with previous backup set")
 	list := flag.Bool("list", false, "List sets")
+	force := flag.Bool("force", false, "Apply lists even if they violate safety thresholds")
+	incremental := flag.Bool("incremental", false, "Update the live set with the entries the list gained and lost instead of swapping in a new set")
 	config := flag.String("config", "", "Use yaml config file")
+	lookup := flag.String("lookup", "", "Show which rules of the config contain an IP, or a comma separated list of IPs")
+	lookupFile := flag.String("lookup-file", "", "Read IPs to look up from file, one per line, - reads from stdin")
+	jsonOutput := flag.Bool("json", false, "Print lookups and live checks as json")
+	watch := flag.Bool("watch", false, "Keep running and refresh the sets of rules with hosts when their records expire")
+	addEntry := flag.String("add", "", "Ban an IP or network in the live set of -set, kept when the set is rebuilt")
+	delEntry := flag.String("del", "", "Remove an IP or network from the live set of -set")
+	timeout := flag.Duration("timeout", 0, "Remove the entry of -add after this long, e.g. 6h. Without it, the ban stays until -del")
 	help := flag.Bool("help", false, "Show help")
 	flag.Parse()
 
@@ -38,9 +49,21 @@ Options:
 	-help					show this menu
 
 	-config		{PATH}			Read config from yaml file
+	-force					apply lists from config even if they violate safety thresholds
+	-watch					keep running after building the config and re-resolve hosts when their TTL expires
+	-lookup		{IP}			show which rules, sources and sets of the config contain IP. accepts a comma separated list
+	-lookup-file	{PATH}			look up the IPs of a file, one per line. - reads from stdin
+	-json					print lookups and live checks as json
 	-country	{CODE}			set country code. is not case sensitive.
 	-set		{NAME}			name of ipset set
 	-check		{IP}			check if IP exists in specific country IP pool
+	-check		{IP}			without -country, test IP against the live sets of -set, of -config or all sets.
+						accepts a comma separated list. exits 0 if all IPs are in a set, 1 if not, 2 on errors
+	-check-file	{PATH}			check the IPs of a file against the live sets, one per line. - reads from stdin
+
+	-add		{ENTRY}			ban an IP or network in the live set of -set. bans are kept when the set is rebuilt
+	-del		{ENTRY}			remove a ban, or any entry until the set is rebuilt, from the live set of -set
+	-timeout	{DURATION}		remove the ban of -add after DURATION, e.g. 6h. at most 596h
 
 	-file		{PATH}			file path to read networks from (by default, it will be fetched from github)
 	-export					export to file. works with -file and -country
@@ -48,6 +71,7 @@ Options:
 	-iptables				setup iptables rules
 	-chain		{CHAIN}			iptables chain to add rules to. defaults to INPUT
 	-policy		{POLICY}		works with -iptables and sets default policy
+	-incremental				add and delete the entries that changed instead of rebuilding the set. set incremental in config files
 	
 	-rollback	{SETNAME}		rollback set with previous backup set
 
@@ -58,6 +82,8 @@ Options:
 
 	-v					verbose mode
 
+Every set is created for IPv4 and IPv6. The IPv6 set is named {SETNAME}-v6.
+
 Example usage:
 
 List rules:
@@ -69,9 +95,24 @@ List specific rule:
 Read rules from config file and setup ipset:
 	ipsetfw -config ipsetfw.yml
 
+Read rules from config file and keep the addresses of hosts up to date:
+	ipsetfw -config ipsetfw.yml -watch
+
+Show which rules of the config contain an IP:
+	ipsetfw -config ipsetfw.yml -lookup 1.1.1.1
+
+Look up a list of IPs and print json:
+	ipsetfw -config ipsetfw.yml -lookup-file /tmp/ips.txt -json
+
 Clear rules defined in config file:
 	ipsetfw -config ipsetfw.yml -clear
 
+Ban a network in the set of a rule for 6 hours:
+	ipsetfw -add 203.0.113.0/24 -set ir-block -timeout 6h
+
+Lift the ban before it expires:
+	ipsetfw -del 203.0.113.0/24 -set ir-block
+
 Rollback a broken update to previous working set:
 	ipsetfw -rollback -set ir-block
 
@@ -84,6 +125,9 @@ Create a set of Iran IP pool:
 Create a set of Iran IP pool and block IPs from Iran by adding iptables rule:
 	ipsetfw -country IR -set set -iptables -policy drop
 
+Update the set of Iran IP pool with the networks that changed since it was created:
+	ipsetfw -country IR -set set -incremental
+
 Create a set of Iran IP pool and accpet IPs from Iran by adding iptable rules with verbose mode:
 	ipsetfw -country IR -set set -iptables -policy accept -v
 
@@ -94,12 +138,19 @@ Create a set of Iran IP pool and accpet IPs from Iran from file:
 	ipsetfw -country IR -set set -iptables -policy accept -file /tmp/list-export.txt
 
 Check if IP exists in IR (Iran):
-	ipsetfw -country ir -check 1.1.1.1`)
+	ipsetfw -country ir -check 1.1.1.1
+
+Check if IPs are in the live sets of a rule:
+	ipsetfw -check 1.1.1.1,2001:db8::1 -set ir-block
+
+Check a list of IPs against the live sets of all rules of the config file and print json:
+	ipsetfw -config ipsetfw.yml -check-file /tmp/ips.txt -json`)
 		os.Exit(1)
 	}
 	set := models.Set{
-		Country: *countryCode,
-		SetName: *setName,
+		Country:     *countryCode,
+		SetName:     *setName,
+		Incremental: *incremental,
 	}
 	rule := models.Rule{
 		Policy: *iptablesPolicy,
@@ -107,8 +158,22 @@ Check if IP exists in IR (Iran):
 	if *export {
 		ipList := netutils.FetchIPPool(*countryCode, *verbose, "", "")
 		file.ExportToFile(*filePath, ipList, *verbose)
+	} else if *addEntry != "" && *setName != "" {
+		checkerr.Fatal(ipsetfw.AddBan(*setName, *addEntry, *timeout))
+	} else if *delEntry != "" && *setName != "" {
+		checkerr.Fatal(ipsetfw.RemoveBan(*setName, *delEntry))
+	} else if *countryCode == "" && (*checkIP != "" || *checkFile != "") {
+		addresses, err := ipsetfw.ReadAddresses(*checkIP, *checkFile)
+		checkerr.Fatal(err)
+		os.Exit(ipsetfw.CheckLiveSets(addresses, *setName, *config, *jsonOutput))
+	} else if *config != "" && (*lookup != "" || *lookupFile != "") {
+		addresses, err := ipsetfw.ReadAddresses(*lookup, *lookupFile)
+		checkerr.Fatal(err)
+		checkerr.Fatal(ipsetfw.LookupConfigFile(*config, addresses, *jsonOutput, *verbose))
+	} else if *config != "" && *watch && !*clear {
+		checkerr.Fatal(ipsetfw.WatchConfigFile(*config, *iptables, *verbose, *force))
 	} else if *config != "" && !*clear {
-		ipsetfw.LoopConfigFile(*config, *iptables, *verbose)
+		checkerr.Fatal(ipsetfw.LoopConfigFile(*config, *iptables, *verbose, *force))
 	} else if *list && *setName != "" {
 		ipsetfw.ListSet(*setName, *verbose)
 	} else if *list {
@@ -119,7 +184,7 @@ Check if IP exists in IR (Iran):
 		ipsetfw.LoopConfigFileClear(*config, *iptables, *verbose)
 	} else if *countryCode != "" && *setName != "" {
 		ipList := netutils.FetchIPPool(*countryCode, *verbose, *filePath, "")
-		ipsetfw.IPsetfw(ipList, set, *iptables, *chain, rule, file.Mattermost{}, "", *verbose)
+		checkerr.Fatal(ipsetfw.IPsetfw(ipList, set, *iptables, *chain, rule, file.Mattermost{}, nil, "", *verbose))
 	} else if *countryCode != "" && *checkIP != "" {
 		ipList := netutils.FetchIPPool(*countryCode, *verbose, *filePath, "")
 		netutils.CheckIPExistsInPool(ipList, *checkIP, *verbose)
@@ -11,11 +11,16 @@ require (
 
 require (
 	github.com/EvilSuperstars/go-cidrman v0.0.0-20190607145828-28e79e32899a
+	github.com/ProtonMail/go-crypto v1.0.0
 	github.com/lrh3321/ipset-go v0.0.0-20230425010353-0d9880b1ecac
+	github.com/oschwald/maxminddb-golang v1.10.0
+	github.com/vishvananda/netlink v1.2.1-beta.2
+	golang.org/x/crypto v0.7.0
+	golang.org/x/net v0.8.0
+	golang.org/x/sys v0.6.0
 )
 
 require (
-	github.com/vishvananda/netlink v1.2.1-beta.2 // indirect
+	github.com/cloudflare/circl v1.3.3 // indirect
 	github.com/vishvananda/netns v0.0.4 // indirect
-	golang.org/x/sys v0.5.0 // indirect
 )
@@ -1,8 +1,50 @@
 package models
 
+import "time"
+
 type Set struct {
 	Country string
 	SetName string
+	// Timeout is the number of seconds entries stay in the set, 0 if they never expire
+	Timeout uint32
+	// Type is the ipset type, hash:net by default
+	Type string
+	// Ports and Interfaces are combined with every network of sets whose type has a port or iface
+	Ports      []string
+	Interfaces []string
+	Options    IPSetOptions
+	// Comment is the comment of entries of sets with comments, unless
+	// References gives the upstream reference of their network
+	Comment    string
+	References map[string]string
+	// Incremental applies the difference between the list and the live set
+	// instead of swapping in a rebuilt set, when the live set can hold the list
+	Incremental bool
+}
+
+// IPSetOptions are the create options of the sets of a rule. Zero values
+// are picked by ipsetfw, maxelem and hashsize from the length of the list.
+type IPSetOptions struct {
+	MaxElem  uint32 `yaml:"maxelem"`
+	HashSize uint32 `yaml:"hashsize"`
+	// Timeout is how long entries stay in the set, they never expire if it's 0
+	Timeout  time.Duration `yaml:"timeout"`
+	Counters bool          `yaml:"counters"`
+	// Comment comments entries with the reference the list gave them, e.g. a
+	// Spamhaus SBL id, or with the name of the rule
+	Comment bool `yaml:"comment"`
+	Skbinfo bool `yaml:"skbinfo"`
+}
+
+// Safety guards against swapping in a list that changed suspiciously,
+// e.g. because the upstream file was truncated
+type Safety struct {
+	// MaxShrinkPercent aborts the update if the list lost more than this share of the live set
+	MaxShrinkPercent float64 `yaml:"maxShrinkPercent"`
+	// MaxGrowthPercent aborts the update if the list grew by more than this share of the live set
+	MaxGrowthPercent float64 `yaml:"maxGrowthPercent"`
+	// MinEntries aborts the update if the list has fewer entries
+	MinEntries int `yaml:"minEntries"`
 }
 type Rule struct {
 	Policy string   `yaml:"policy"`
@@ -12,3 +54,125 @@ type Rule struct {
 	Chain  string   `yaml:"chain"`
 	Table  string   `yaml:"table"`
 }
+
+// Source selects the provider an IP list is fetched from and its parameters
+type Source struct {
+	Type    string `yaml:"type"`
+	Country string `yaml:"country"`
+	// Countries are fetched and merged together with Country, they may name country groups
+	Countries []string `yaml:"countries"`
+	Path      []string `yaml:"file"`
+	URL       []string `yaml:"url"`
+	Format    Format   `yaml:"format"`
+
+	// Database is a GeoLite2/DB-IP mmdb or csv file, or a directory of GeoLite2 csv files
+	Database string `yaml:"database"`
+	// Locations is the GeoLite2 locations csv, looked up next to Database if empty
+	Locations string `yaml:"locations"`
+	// Status filters RIR delegated records, allocated and assigned by default
+	Status []string `yaml:"status"`
+	// ASN lists the origin AS numbers of an asn source, e.g. 13335 or AS13335
+	ASN    []string `yaml:"asn"`
+	Verify Verify   `yaml:"verify"`
+
+	// Provider is the cloud provider of a cloud source: aws, gcp, azure, cloudflare, fastly or github
+	Provider string `yaml:"provider"`
+	// Service and Region filter cloud ranges, they accept globs like eu-*
+	Service StringList `yaml:"service"`
+	Region  StringList `yaml:"region"`
+	// Hosts are resolved to their A and AAAA records by a dns source
+	Hosts []string `yaml:"hosts"`
+}
+
+// StringList is a list of strings that may be given as a single string in yaml
+type StringList []string
+
+// UnmarshalYAML accepts both `service: EC2` and `service: [EC2, S3]`
+func (l *StringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var single string
+	if err := unmarshal(&single); err == nil {
+		*l = StringList{single}
+		return nil
+	}
+	return unmarshal((*[]string)(l))
+}
+
+// Verify configures the integrity checks of downloaded lists
+type Verify struct {
+	// SHA256 is the expected hex digest of the list
+	SHA256 string `yaml:"sha256"`
+	// ChecksumURL points to a sha256sum style file holding the digest of the list
+	ChecksumURL string `yaml:"checksumURL"`
+	// Signature is the type of the detached signature, minisign or pgp
+	Signature string `yaml:"signature"`
+	// SignatureURL defaults to the list url plus .minisig or .asc
+	SignatureURL string `yaml:"signatureURL"`
+	// PublicKey is the minisign public key or OpenPGP key file signatures are checked against
+	PublicKey string `yaml:"publicKey"`
+}
+
+// GeoDatabase is a local country database used instead of fetching country lists from github
+type GeoDatabase struct {
+	Path      string `yaml:"path"`
+	Locations string `yaml:"locations"`
+}
+
+// Format describes how a list is laid out.
+// Type is one of plain, commented, csv or json.
+type Format struct {
+	Type string `yaml:"type"`
+	// Comment is the comment marker of commented lists, "#" and ";" by default
+	Comment string `yaml:"comment"`
+	// Column is the 1-based csv column holding the network
+	Column int `yaml:"column"`
+	// Field is the csv header name of the column holding the network
+	Field     string `yaml:"field"`
+	Delimiter string `yaml:"delimiter"`
+	// Path is a JSONPath expression selecting the networks of a json document
+	Path string `yaml:"path"`
+}
+
+// UnmarshalYAML allows a format to be given as a plain string, e.g. `format: csv`
+func (f *Format) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var formatType string
+	if err := unmarshal(&formatType); err == nil {
+		f.Type = formatType
+		return nil
+	}
+	type plain Format
+	return unmarshal((*plain)(f))
+}
+
+// Cache configures the on-disk cache of fetched lists
+type Cache struct {
+	Dir string `yaml:"dir"`
+	// MaxAge limits how old a cached list may be when it's used because fetching failed
+	MaxAge time.Duration `yaml:"maxAge"`
+}
+
+// HTTP configures the client used for downloading lists and sending notifications
+type HTTP struct {
+	// Timeout limits a whole request including reading the body, 30s by default
+	Timeout time.Duration `yaml:"timeout"`
+	// Retries is how many times a failed request is retried
+	Retries int `yaml:"retries"`
+	// Backoff is the delay before the first retry, doubled on every further retry
+	Backoff time.Duration `yaml:"backoff"`
+	// Proxy is an http, https or socks5 proxy url, the environment's proxy is used if empty
+	Proxy string `yaml:"proxy"`
+	// CABundle is a PEM file of certificates trusted in addition to the system ones
+	CABundle  string `yaml:"caBundle"`
+	UserAgent string `yaml:"userAgent"`
+	// Mirrors maps a url prefix to prefixes tried in order when the original fails
+	Mirrors map[string][]string `yaml:"mirrors"`
+}
+
+// DNS configures the resolver of dns sources
+type DNS struct {
+	// Server is the host:port of the DNS server, the first nameserver of /etc/resolv.conf by default
+	Server string `yaml:"server"`
+	// Timeout limits a single query, 5s by default
+	Timeout time.Duration `yaml:"timeout"`
+	// MinTTL is the shortest refresh interval in watch mode, 30s by default
+	MinTTL time.Duration `yaml:"minTTL"`
+}
@@ -0,0 +1,312 @@
+package ipsetfw
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sabershahhoseini/ipset-firewall/util/ipsetnl"
+	"github.com/sabershahhoseini/ipset-firewall/util/logger"
+	"github.com/sabershahhoseini/ipset-firewall/util/netutils"
+	"github.com/sabershahhoseini/ipset-firewall/util/usermgmt"
+
+	"github.com/lrh3321/ipset-go"
+)
+
+// BansFile records the entries added with AddBan, so rebuilds of their set keep them
+var BansFile = "/var/lib/ipsetfw/bans.json"
+
+// maxBanTimeout is the longest timeout the kernel accepts, IPSET_MAX_TIMEOUT
+const maxBanTimeout = 2147483 * time.Second
+
+// banComment is the comment of bans in sets with comments
+const banComment = "ban"
+
+// ban is an entry added to a set outside of its list
+type ban struct {
+	// Set is the set of the entry's family, e.g. ir-block-v6
+	Set     string `json:"set"`
+	Element string `json:"element"`
+	// Expires is when the entry times out, nil for bans that don't
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+func (b ban) expired(now time.Time) bool {
+	return b.Expires != nil && !now.Before(*b.Expires)
+}
+
+// readBans returns the bans of BansFile that haven't expired
+func readBans() ([]ban, error) {
+	body, err := os.ReadFile(BansFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var all []ban
+	if err := json.Unmarshal(body, &all); err != nil {
+		return nil, fmt.Errorf("invalid bans file %s: %w", BansFile, err)
+	}
+	var bans []ban
+	now := time.Now()
+	for _, b := range all {
+		if !b.expired(now) {
+			bans = append(bans, b)
+		}
+	}
+	return bans, nil
+}
+
+// writeBans replaces BansFile with bans
+func writeBans(bans []ban) error {
+	if bans == nil {
+		bans = []ban{}
+	}
+	body, err := json.MarshalIndent(bans, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(BansFile), 0755); err != nil {
+		return err
+	}
+	tmpPath := BansFile + ".tmp"
+	if err := os.WriteFile(tmpPath, body, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, BansFile)
+}
+
+// setBans returns the bans of setName
+func setBans(setName string) ([]ban, error) {
+	bans, err := readBans()
+	if err != nil {
+		return nil, err
+	}
+	var own []ban
+	for _, b := range bans {
+		if b.Set == setName {
+			own = append(own, b)
+		}
+	}
+	return own, nil
+}
+
+// dropBans forgets the bans of setNames, e.g. because their sets were cleared
+func dropBans(setNames ...string) error {
+	bans, err := readBans()
+	if err != nil || len(bans) == 0 {
+		return err
+	}
+	drop := map[string]bool{}
+	for _, name := range setNames {
+		drop[name] = true
+	}
+	var kept []ban
+	for _, b := range bans {
+		if !drop[b.Set] {
+			kept = append(kept, b)
+		}
+	}
+	if len(kept) == len(bans) {
+		return nil
+	}
+	return writeBans(kept)
+}
+
+// banEntry returns the entry of b in a set of setType with the time it has
+// left. The timeout is explicit, so bans outlive the default timeout of a set.
+func banEntry(b ban, setType string, now time.Time) (ipset.Entry, error) {
+	entry, err := parseElement(setType, b.Element)
+	if err != nil {
+		return entry, err
+	}
+	var timeout uint32
+	if b.Expires != nil {
+		timeout = uint32((b.Expires.Sub(now) + time.Second - 1) / time.Second)
+	}
+	entry.Timeout = &timeout
+	return entry, nil
+}
+
+// banEntries returns the entries of the bans of setName that can be kept in
+// a set of setType. Bans of another type, left over from before the set
+// changed type, are skipped.
+func banEntries(setName string, setType string, logFilePath string, verbose bool) ([]string, []ipset.Entry) {
+	bans, err := setBans(setName)
+	if err != nil {
+		logger.Log("WARNING: Could not read bans of set "+setName+": "+err.Error(), logFilePath, true)
+		return nil, nil
+	}
+	var elements []string
+	var entries []ipset.Entry
+	now := time.Now()
+	for _, b := range bans {
+		entry, err := banEntry(b, setType, now)
+		if err != nil {
+			logger.Log("WARNING: Dropping ban "+b.Element+" of set "+setName+": "+err.Error(), logFilePath, verbose)
+			continue
+		}
+		elements = append(elements, b.Element)
+		entries = append(entries, entry)
+	}
+	return elements, entries
+}
+
+// addBanEntries adds the bans of a set to setName. Bans already in the list
+// of the set are skipped.
+func addBanEntries(setName string, elements []string, entries []ipset.Entry, comments bool, logFilePath string, verbose bool) error {
+	for i := range entries {
+		logger.Log("Keeping ban "+elements[i]+" in set "+setName, logFilePath, verbose)
+		if comments {
+			entries[i].Comment = banComment
+		}
+		err := ipsetnl.Add(setName, &entries[i])
+		switch {
+		case err == ipset.ErrEntryExist:
+		case err == ipsetnl.ErrHashFull:
+			return fmt.Errorf("set %s is full, raise its maxelem to keep ban %s", setName, elements[i])
+		case err != nil:
+			return fmt.Errorf("could not keep ban %s in set %s: %w", elements[i], setName, err)
+		}
+	}
+	return nil
+}
+
+// banTarget returns the live set of setName holding element, of its family,
+// and the entry of element in it
+func banTarget(setName string, element string) (*ipset.Sets, ipset.Entry, error) {
+	address, _, _ := strings.Cut(element, ",")
+	prefix, ok := netutils.ParsePrefix(strings.TrimSpace(address))
+	if !ok {
+		return nil, ipset.Entry{}, errors.New("invalid element " + element)
+	}
+	familySetName := setName
+	if prefix.Addr().Is6() {
+		familySetName += families[1].setSuffix
+	}
+	header, err := ipsetnl.Header(familySetName)
+	if err != nil {
+		return nil, ipset.Entry{}, errors.New("set " + familySetName + " does not exist")
+	}
+	entry, err := parseElement(header.TypeName, element)
+	if err != nil {
+		return nil, ipset.Entry{}, err
+	}
+	return header, entry, nil
+}
+
+// AddBan adds element, e.g. 203.0.113.0/24, to the live set of setName of
+// its family. It's removed after timeout, or stays until RemoveBan if timeout
+// is 0. Bans are recorded in BansFile and kept when the set is rebuilt.
+func AddBan(setName string, element string, timeout time.Duration) error {
+	usermgmt.ExitIfNotRoot()
+	if timeout < 0 || (timeout > 0 && timeout < time.Second) {
+		return errors.New("timeout must be at least 1s")
+	}
+	if timeout > maxBanTimeout {
+		return fmt.Errorf("timeout must be at most %v", maxBanTimeout)
+	}
+	header, entry, err := banTarget(setName, element)
+	if err != nil {
+		return err
+	}
+	element = formatEntry(header.TypeName, entry)
+	b := ban{Set: header.SetName, Element: element}
+	if timeout > 0 {
+		if header.Timeout == nil {
+			return errors.New("set " + header.SetName + " has no timeout support, it gets it when it's rebuilt")
+		}
+		expires := time.Now().Add(timeout)
+		b.Expires = &expires
+	}
+	if header.Timeout != nil {
+		seconds := uint32(timeout / time.Second)
+		entry.Timeout = &seconds
+	}
+	if header.CadtFlags&ipset.IPSET_FLAG_WITH_COMMENT != 0 {
+		entry.Comment = banComment
+	}
+
+	bans, err := readBans()
+	if err != nil {
+		return err
+	}
+	existing := -1
+	for i := range bans {
+		if bans[i].Set == b.Set && bans[i].Element == b.Element {
+			existing = i
+		}
+	}
+	err = ipsetnl.Add(header.SetName, &entry)
+	if err == ipset.ErrEntryExist && existing >= 0 {
+		entry.Replace = true
+		err = ipsetnl.Add(header.SetName, &entry)
+	}
+	if err == ipset.ErrEntryExist {
+		return errors.New(element + " is already in set " + header.SetName)
+	}
+	if err != nil {
+		return fmt.Errorf("could not add %s to set %s: %w", element, header.SetName, err)
+	}
+	if existing >= 0 {
+		bans[existing] = b
+	} else {
+		bans = append(bans, b)
+	}
+	err = writeBans(bans)
+	if err != nil {
+		return fmt.Errorf("added %s to set %s, but could not record it, it's dropped when the set is rebuilt: %w",
+			element, header.SetName, err)
+	}
+	if timeout > 0 {
+		fmt.Println("Banned " + element + " in set " + header.SetName + " until " + b.Expires.Format("2006-01-02 15:04:05"))
+	} else {
+		fmt.Println("Banned " + element + " in set " + header.SetName)
+	}
+	return nil
+}
+
+// RemoveBan removes element from the live set of setName of its family and
+// from the recorded bans. Elements of the list of the set come back when
+// it's rebuilt.
+func RemoveBan(setName string, element string) error {
+	usermgmt.ExitIfNotRoot()
+	header, entry, err := banTarget(setName, element)
+	if err != nil {
+		return err
+	}
+	element = formatEntry(header.TypeName, entry)
+	bans, err := readBans()
+	if err != nil {
+		return err
+	}
+	var kept []ban
+	for _, b := range bans {
+		if b.Set != header.SetName || b.Element != element {
+			kept = append(kept, b)
+		}
+	}
+	isBan := len(kept) != len(bans)
+
+	err = ipsetnl.Del(header.SetName, &entry)
+	switch {
+	case err == ipset.ErrEntryNotExist && !isBan:
+		return errors.New(element + " is not in set " + header.SetName)
+	case err != nil && err != ipset.ErrEntryNotExist:
+		return fmt.Errorf("could not remove %s from set %s: %w", element, header.SetName, err)
+	}
+	if isBan {
+		if err := writeBans(kept); err != nil {
+			return err
+		}
+		fmt.Println("Removed ban " + element + " from set " + header.SetName)
+		return nil
+	}
+	fmt.Println("Removed " + element + " from set " + header.SetName + ", it's part of its list and comes back when the set is rebuilt")
+	return nil
+}
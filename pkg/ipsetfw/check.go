@@ -0,0 +1,180 @@
+package ipsetfw
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/sabershahhoseini/ipset-firewall/util/file"
+	"github.com/sabershahhoseini/ipset-firewall/util/ipsetnl"
+
+	"github.com/lrh3321/ipset-go"
+)
+
+// Exit codes of CheckLiveSets, like grep's
+const (
+	CheckFound    = 0
+	CheckNotFound = 1
+	CheckError    = 2
+)
+
+// checkTarget is a live set addresses of its family are tested against
+type checkTarget struct {
+	name   string
+	family uint8
+}
+
+type checkResult struct {
+	Address string   `json:"address"`
+	Found   bool     `json:"found"`
+	Sets    []string `json:"sets"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// internalSet reports whether name is a set ipsetfw uses to swap or back up a set
+func internalSet(name string) bool {
+	return strings.HasSuffix(name, "-tmp") || strings.HasSuffix(name, "-bak")
+}
+
+// testable reports whether addresses can be tested against a set of
+// setType, i.e. whether its elements are addresses or networks alone
+func testable(setType string) bool {
+	return setType == ipset.TypeHashIP || setType == ipset.TypeHashNet
+}
+
+// checkTargets returns the sets to test: the sets of all families of
+// setName, of every rule of the config at configPath, or every live set but
+// temporary and backup sets. Sets of setName or the config that don't exist
+// or whose elements have more dimensions than an address are skipped, but at
+// least one of them must be testable.
+func checkTargets(setName string, configPath string) ([]checkTarget, error) {
+	headers, err := ipsetnl.ListHeaders()
+	if err != nil {
+		return nil, err
+	}
+	live := map[string]ipset.Sets{}
+	for _, header := range headers {
+		live[header.SetName] = header
+	}
+
+	var setNames []string
+	switch {
+	case setName != "":
+		setNames = []string{setName}
+	case configPath != "":
+		inventory := file.DecodeConfig(file.ReadConfigFile(configPath))
+		for _, r := range inventory.IPSetRules {
+			if r.SetName != "" {
+				setNames = append(setNames, r.SetName)
+			}
+		}
+	default:
+		var targets []checkTarget
+		for _, header := range headers {
+			if !internalSet(header.SetName) && testable(header.TypeName) {
+				targets = append(targets, checkTarget{name: header.SetName, family: header.Family})
+			}
+		}
+		return targets, nil
+	}
+
+	var targets []checkTarget
+	var untestable []string
+	for _, name := range setNames {
+		for _, f := range families {
+			header, ok := live[name+f.setSuffix]
+			switch {
+			case !ok:
+			case !testable(header.TypeName):
+				untestable = append(untestable, header.SetName+" ("+header.TypeName+")")
+			default:
+				targets = append(targets, checkTarget{name: header.SetName, family: header.Family})
+			}
+		}
+	}
+	if len(targets) == 0 && len(untestable) > 0 {
+		return nil, errors.New("only hash:ip and hash:net sets can be checked, not " + strings.Join(untestable, ", "))
+	}
+	if len(targets) == 0 && len(setNames) == 1 {
+		return nil, errors.New("set " + setNames[0] + " does not exist")
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("none of the sets " + strings.Join(setNames, ", ") + " exist")
+	}
+	return targets, nil
+}
+
+// CheckLiveSets tests addresses against the live sets of setName, of the
+// rules of the config at configPath, or against every set if both are empty,
+// without fetching any list. It prints the sets containing each address and
+// returns CheckFound if all addresses are in a set, CheckNotFound if any
+// isn't and CheckError if an address is invalid or the sets can't be tested.
+func CheckLiveSets(addresses []string, setName string, configPath string, jsonOutput bool) int {
+	targets, err := checkTargets(setName, configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return CheckError
+	}
+
+	code := CheckFound
+	results := make([]checkResult, len(addresses))
+	for i, address := range addresses {
+		result := checkResult{Address: address, Sets: []string{}}
+		addr, err := netip.ParseAddr(address)
+		if err != nil {
+			result.Error = "invalid IP address"
+			results[i] = result
+			code = CheckError
+			continue
+		}
+		addr = addr.Unmap()
+		family := uint8(ipset.FamilyIPV4)
+		if addr.Is6() {
+			family = ipset.FamilyIPV6
+		}
+		for _, target := range targets {
+			if target.family != family {
+				continue
+			}
+			found, err := ipsetnl.Test(target.name, &ipset.Entry{IP: net.IP(addr.AsSlice())})
+			if err != nil {
+				result.Error = "testing set " + target.name + ": " + err.Error()
+				code = CheckError
+				break
+			}
+			if found {
+				result.Sets = append(result.Sets, target.name)
+			}
+		}
+		result.Found = len(result.Sets) > 0
+		if !result.Found && code == CheckFound {
+			code = CheckNotFound
+		}
+		results[i] = result
+	}
+
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(results); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return CheckError
+		}
+		return code
+	}
+	for _, result := range results {
+		switch {
+		case result.Error != "":
+			fmt.Println(result.Address + ": " + result.Error)
+		case result.Found:
+			fmt.Println(result.Address + ": in " + strings.Join(result.Sets, ", "))
+		default:
+			fmt.Println(result.Address + ": not in any set")
+		}
+	}
+	return code
+}
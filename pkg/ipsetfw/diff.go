@@ -0,0 +1,211 @@
+package ipsetfw
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+	"github.com/sabershahhoseini/ipset-firewall/util/ipsetnl"
+	"github.com/sabershahhoseini/ipset-firewall/util/logger"
+
+	"github.com/lrh3321/ipset-go"
+)
+
+// extensionFlags are the cadt flags of the extensions sets are created with
+const extensionFlags = ipset.IPSET_FLAG_WITH_COUNTERS | ipset.IPSET_FLAG_WITH_COMMENT | ipset.IPSET_FLAG_WITH_SKBINFO
+
+// setDiff counts the changes an update made to a set
+type setDiff struct {
+	setName string
+	added   int
+	deleted int
+	// updated counts entries added again because their comment changed
+	updated int
+	// swapped is set if the set was rebuilt and swapped in instead
+	swapped bool
+}
+
+func (d setDiff) String() string {
+	if d.swapped {
+		return d.setName + " rebuilt"
+	}
+	diff := d.setName + " +" + strconv.Itoa(d.added) + " -" + strconv.Itoa(d.deleted)
+	if d.updated > 0 {
+		diff += " ~" + strconv.Itoa(d.updated)
+	}
+	return diff
+}
+
+// swapReason returns why live can't be updated in place to hold entries
+// entries of set, or "" if it can. Create options can only change by
+// swapping in a new set, and entries with a default timeout are refreshed by
+// rebuilding the set.
+func swapReason(live *ipset.Sets, set models.Set, f family, entries int) string {
+	options, maxElem, err := createOptions(set, f, entries)
+	switch {
+	case err != nil:
+		return err.Error()
+	case live.TypeName != setTypeOf(set):
+		return "its type changed"
+	case live.Timeout == nil:
+		return "it has no timeout support"
+	case *live.Timeout != 0 || options.Timeout != 0:
+		return "its entries expire"
+	case live.CadtFlags&extensionFlags != options.CadtFlags():
+		return "its extensions changed"
+	case set.Options.MaxElem != 0 && live.MaxElements != maxElem:
+		return "its maxelem changed"
+	case int(live.MaxElements) < entries:
+		return "its maxelem is too small for " + strconv.Itoa(entries) + " entries"
+	}
+	return ""
+}
+
+// updateSet brings setName to hold elements and its bans. Sets that don't
+// exist, and sets of incremental rules that can't be updated in place, are
+// rebuilt with swapInSet. Otherwise only the entries the live set lacks are
+// added and those no longer listed deleted, leaving the backup set as it was.
+func updateSet(elements []string, setName string, set models.Set, f family, logFilePath string, verbose bool) (setDiff, error) {
+	diff := setDiff{setName: setName, swapped: true}
+	if set.Incremental {
+		live, err := ipsetnl.List(setName)
+		if err == nil {
+			_, bans := banEntries(setName, setTypeOf(set), "", false)
+			reason := swapReason(live, set, f, len(elements)+len(bans))
+			if reason == "" {
+				diff, err := diffSet(live, elements, setName, set, logFilePath, verbose)
+				if err != errNoRoom {
+					return diff, err
+				}
+				reason = err.Error()
+			}
+			logger.Log("Rebuilding set "+setName+" instead of updating it, "+reason, logFilePath, verbose)
+		}
+	}
+	return diff, swapInSet(elements, setName, set, f, logFilePath, verbose)
+}
+
+// errNoRoom is returned by diffSet if live can't hold the added entries next
+// to the entries they replace
+var errNoRoom = errors.New("it can't hold the added entries next to the deleted ones")
+
+// setPlan are the changes that bring a live set to hold a list, by their
+// elements in the syntax formatEntry gives them
+type setPlan struct {
+	want          map[string]ipset.Entry
+	adds          []string
+	deletes       []string
+	deleteEntries []ipset.Entry
+	updates       []string
+}
+
+// planDiff plans the changes that bring live to hold elements and the bans
+// banElements. Entries whose comment changed are updated. It returns
+// errNoRoom if live can't hold the added entries before the deleted ones
+// are gone.
+func planDiff(live *ipset.Sets, elements []string, set models.Set, banElements []string, bans []ipset.Entry) (setPlan, error) {
+	plan := setPlan{want: map[string]ipset.Entry{}}
+	setType := setTypeOf(set)
+
+	var order []string
+	for _, element := range elements {
+		entry, err := setEntry(set, element)
+		if err != nil {
+			return plan, err
+		}
+		key := formatEntry(setType, entry)
+		if _, ok := plan.want[key]; !ok {
+			order = append(order, key)
+		}
+		plan.want[key] = entry
+	}
+	isBan := map[string]bool{}
+	for i, element := range banElements {
+		if _, ok := plan.want[element]; ok {
+			continue
+		}
+		if set.Options.Comment {
+			bans[i].Comment = banComment
+		}
+		isBan[element] = true
+		plan.want[element] = bans[i]
+		order = append(order, element)
+	}
+
+	present := map[string]bool{}
+	for _, entry := range live.Entries {
+		key := formatEntry(setType, entry)
+		wanted, ok := plan.want[key]
+		switch {
+		case !ok:
+			// Deleting by the entry of the element leaves out its timeout and counters
+			element, err := parseElement(setType, key)
+			if err != nil {
+				return plan, err
+			}
+			plan.deletes = append(plan.deletes, key)
+			plan.deleteEntries = append(plan.deleteEntries, element)
+		case !isBan[key] && set.Options.Comment && entry.Comment != wanted.Comment:
+			present[key] = true
+			plan.updates = append(plan.updates, key)
+		default:
+			present[key] = true
+		}
+	}
+	for _, key := range order {
+		if !present[key] {
+			plan.adds = append(plan.adds, key)
+		}
+	}
+	if len(live.Entries)+len(plan.adds) > int(live.MaxElements) {
+		return plan, errNoRoom
+	}
+	return plan, nil
+}
+
+// diffSet adds the elements and bans live lacks, then deletes the entries of
+// live that are neither, so no address goes unmatched in between. Entries
+// whose comment changed are added again.
+func diffSet(live *ipset.Sets, elements []string, setName string, set models.Set, logFilePath string, verbose bool) (setDiff, error) {
+	diff := setDiff{setName: setName}
+	banElements, bans := banEntries(setName, setTypeOf(set), logFilePath, verbose)
+	plan, err := planDiff(live, elements, set, banElements, bans)
+	if err != nil {
+		return diff, err
+	}
+
+	for _, key := range plan.adds {
+		entry := plan.want[key]
+		logger.Log("Adding "+key+" to set "+setName, logFilePath, verbose)
+		err := ipsetnl.Add(setName, &entry)
+		switch {
+		case err == ipset.ErrEntryExist:
+			continue
+		case err == ipsetnl.ErrHashFull:
+			return diff, fmt.Errorf("set %s is full after adding %d entries, raise its maxelem", setName, diff.added)
+		case err != nil:
+			return diff, fmt.Errorf("could not add %s to set %s: %w", key, setName, err)
+		}
+		diff.added++
+	}
+	for i, key := range plan.deletes {
+		logger.Log("Deleting "+key+" from set "+setName, logFilePath, verbose)
+		err := ipsetnl.Del(setName, &plan.deleteEntries[i])
+		if err != nil && err != ipset.ErrEntryNotExist {
+			return diff, fmt.Errorf("could not delete %s from set %s: %w", key, setName, err)
+		}
+		diff.deleted++
+	}
+	for _, key := range plan.updates {
+		entry := plan.want[key]
+		entry.Replace = true
+		err := ipsetnl.Add(setName, &entry)
+		if err != nil {
+			return diff, fmt.Errorf("could not update %s in set %s: %w", key, setName, err)
+		}
+		diff.updated++
+	}
+	logger.Log("Updated set "+setName+" in place: "+diff.String(), logFilePath, verbose)
+	return diff, nil
+}
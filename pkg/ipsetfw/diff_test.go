@@ -0,0 +1,145 @@
+package ipsetfw
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+
+	"github.com/lrh3321/ipset-go"
+)
+
+// liveSet builds a live hash:net set holding elements
+func liveSet(t *testing.T, maxElem uint32, elements ...string) *ipset.Sets {
+	t.Helper()
+	var timeout uint32
+	live := &ipset.Sets{TypeName: ipset.TypeHashNet, MaxElements: maxElem, Timeout: &timeout}
+	for _, element := range elements {
+		entry, err := parseElement(ipset.TypeHashNet, element)
+		if err != nil {
+			t.Fatal(err)
+		}
+		live.Entries = append(live.Entries, entry)
+	}
+	return live
+}
+
+func TestSwapReason(t *testing.T) {
+	var timeout uint32 = 600
+	tests := []struct {
+		name    string
+		modify  func(live *ipset.Sets)
+		set     models.Set
+		entries int
+		want    string
+	}{
+		{"unchanged", nil, models.Set{}, 100, ""},
+		{"type", nil, models.Set{Type: ipset.TypeHashIP}, 100, "its type changed"},
+		{"no timeout support", func(live *ipset.Sets) { live.Timeout = nil }, models.Set{}, 100, "it has no timeout support"},
+		{"live timeout", func(live *ipset.Sets) { live.Timeout = &timeout }, models.Set{}, 100, "its entries expire"},
+		{"new timeout", nil, models.Set{Timeout: 600}, 100, "its entries expire"},
+		{"extensions", nil, models.Set{Options: models.IPSetOptions{Counters: true}}, 100, "its extensions changed"},
+		{"same extensions", func(live *ipset.Sets) { live.CadtFlags = ipset.IPSET_FLAG_WITH_COUNTERS }, models.Set{Options: models.IPSetOptions{Counters: true}}, 100, ""},
+		{"maxelem", nil, models.Set{Options: models.IPSetOptions{MaxElem: 1000}}, 100, "its maxelem changed"},
+		{"too small", nil, models.Set{}, DefaultMaxElem + 1, "its maxelem is too small for 65537 entries"},
+		{"too large", nil, models.Set{SetName: "block", Options: models.IPSetOptions{MaxElem: 10}}, 11, "set block would hold 11 entries, more than its maxelem 10"},
+	}
+	for _, test := range tests {
+		live := liveSet(t, DefaultMaxElem)
+		if test.modify != nil {
+			test.modify(live)
+		}
+		if got := swapReason(live, test.set, families[0], test.entries); got != test.want {
+			t.Errorf("%s: swapReason = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPlanDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		live     []string
+		maxElem  uint32
+		elements string
+		bans     string
+		adds     string
+		deletes  string
+		err      error
+	}{
+		{"unchanged", []string{"10.0.0.0/8", "192.0.2.0/24"}, 100, "192.0.2.0/24 10.0.0.0/8", "", "", "", nil},
+		{"add and delete", []string{"10.0.0.0/8", "192.0.2.0/24"}, 100, "10.0.0.0/8 198.51.100.0/24", "", "198.51.100.0/24", "192.0.2.0/24", nil},
+		{"duplicates", nil, 100, "10.0.0.0/8 10.0.0.0/8 192.0.2.1", "", "10.0.0.0/8 192.0.2.1/32", "", nil},
+		// Bans are kept in the set
+		{"bans", []string{"203.0.113.1/32"}, 100, "10.0.0.0/8", "203.0.113.1/32 203.0.113.2/32", "10.0.0.0/8 203.0.113.2/32", "", nil},
+		{"no room", []string{"10.0.0.0/8", "192.0.2.0/24"}, 3, "198.51.100.0/24 203.0.113.0/24", "", "", "", errNoRoom},
+		{"room", []string{"10.0.0.0/8", "192.0.2.0/24"}, 4, "198.51.100.0/24 203.0.113.0/24", "",
+			"198.51.100.0/24 203.0.113.0/24", "10.0.0.0/8 192.0.2.0/24", nil},
+	}
+	for _, test := range tests {
+		var bans []ipset.Entry
+		banElements := strings.Fields(test.bans)
+		for _, element := range banElements {
+			entry, err := parseElement(ipset.TypeHashNet, element)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bans = append(bans, entry)
+		}
+		plan, err := planDiff(liveSet(t, test.maxElem, test.live...), strings.Fields(test.elements), models.Set{}, banElements, bans)
+		if err != test.err {
+			t.Errorf("%s: error = %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := strings.Join(plan.adds, " "); got != test.adds {
+			t.Errorf("%s: adds = %s, want %s", test.name, got, test.adds)
+		}
+		if got := strings.Join(plan.deletes, " "); got != test.deletes {
+			t.Errorf("%s: deletes = %s, want %s", test.name, got, test.deletes)
+		}
+		if len(plan.updates) != 0 {
+			t.Errorf("%s: updates = %v, want none", test.name, plan.updates)
+		}
+	}
+}
+
+func TestPlanDiffComments(t *testing.T) {
+	live := liveSet(t, 100, "10.0.0.0/8", "192.0.2.0/24", "203.0.113.1/32")
+	live.Entries[0].Comment = "SBL1"
+	live.Entries[1].Comment = "SBL2"
+	live.Entries[2].Comment = banComment
+	set := models.Set{
+		Options:    models.IPSetOptions{Comment: true},
+		Comment:    "drop",
+		References: map[string]string{"10.0.0.0/8": "SBL1", "192.0.2.0/24": "SBL3"},
+	}
+	ban, _ := parseElement(ipset.TypeHashNet, "203.0.113.1/32")
+	plan, err := planDiff(live, []string{"10.0.0.0/8", "192.0.2.0/24"}, set, []string{"203.0.113.1/32"}, []ipset.Entry{ban})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.adds) != 0 || len(plan.deletes) != 0 || strings.Join(plan.updates, " ") != "192.0.2.0/24" {
+		t.Errorf("plan = +%v -%v ~%v, want ~[192.0.2.0/24]", plan.adds, plan.deletes, plan.updates)
+	}
+	if plan.want["192.0.2.0/24"].Comment != "SBL3" {
+		t.Errorf("updated comment = %q, want SBL3", plan.want["192.0.2.0/24"].Comment)
+	}
+}
+
+func TestSetDiffString(t *testing.T) {
+	tests := []struct {
+		diff setDiff
+		want string
+	}{
+		{setDiff{setName: "block", added: 3, deleted: 1}, "block +3 -1"},
+		{setDiff{setName: "block", added: 0, deleted: 0, updated: 2}, "block +0 -0 ~2"},
+		{setDiff{setName: "block", added: 3, swapped: true}, "block rebuilt"},
+	}
+	for _, test := range tests {
+		if got := test.diff.String(); got != test.want {
+			t.Errorf("%+v.String() = %s, want %s", test.diff, got, test.want)
+		}
+	}
+}
@@ -1,9 +1,10 @@
 package ipsetfw
 
 import (
+	"errors"
 	"fmt"
-	"net"
 	"os"
+	"os/exec"
 	"strconv"
 	"strings"
 	"time"
@@ -12,16 +13,53 @@ import (
 	"github.com/sabershahhoseini/ipset-firewall/error/checkerr"
 	"github.com/sabershahhoseini/ipset-firewall/models"
 	"github.com/sabershahhoseini/ipset-firewall/util/file"
+	"github.com/sabershahhoseini/ipset-firewall/util/httpclient"
+	"github.com/sabershahhoseini/ipset-firewall/util/ipsetnl"
 	"github.com/sabershahhoseini/ipset-firewall/util/logger"
 	"github.com/sabershahhoseini/ipset-firewall/util/netutils"
 	"github.com/sabershahhoseini/ipset-firewall/util/notif"
+	"github.com/sabershahhoseini/ipset-firewall/util/resolver"
 	"github.com/sabershahhoseini/ipset-firewall/util/usermgmt"
 
 	"github.com/lrh3321/ipset-go"
 )
 
-func removeDefaultChain(chainName string, tableName string, logFilePath string, verbose bool) error {
-	ipt, err := iptables.New()
+// family describes an address family every rule is materialized in.
+// Sets of the inet6 family are named after the rule's set plus setSuffix.
+type family struct {
+	name      string
+	ipset     uint8
+	iptables  iptables.Protocol
+	setSuffix string
+}
+
+var families = []family{
+	{name: "inet", ipset: ipset.FamilyIPV4, iptables: iptables.ProtocolIPv4},
+	{name: "inet6", ipset: ipset.FamilyIPV6, iptables: iptables.ProtocolIPv6, setSuffix: "-v6"},
+}
+
+func familyName(f uint8) string {
+	for _, fam := range families {
+		if fam.ipset == f {
+			return fam.name
+		}
+	}
+	return "unknown"
+}
+
+// ip6tablesFound reports whether ip6tables is installed. IPv6 iptables rules
+// are optional, hosts without ip6tables only get a warning.
+func ip6tablesFound(logFilePath string) bool {
+	_, err := exec.LookPath("ip6tables")
+	if err != nil {
+		logger.Log("WARNING: ip6tables not found, leaving IPv6 iptables rules alone", logFilePath, true)
+		return false
+	}
+	return true
+}
+
+func removeDefaultChain(chainName string, tableName string, proto iptables.Protocol, logFilePath string, verbose bool) error {
+	ipt, err := iptables.NewWithProtocol(proto)
 	if err != nil {
 		return err
 	}
@@ -40,8 +78,8 @@ func removeDefaultChain(chainName string, tableName string, logFilePath string,
 	return nil
 }
 
-func removeDefaultChainIptableRule(chainName string, tableName string, logFilePath string, verbose bool, clear bool) error {
-	ipt, err := iptables.New()
+func removeDefaultChainIptableRule(chainName string, tableName string, proto iptables.Protocol, logFilePath string, verbose bool, clear bool) error {
+	ipt, err := iptables.NewWithProtocol(proto)
 	if err != nil {
 		return err
 	}
@@ -65,8 +103,8 @@ func removeDefaultChainIptableRule(chainName string, tableName string, logFilePa
 	return nil
 }
 
-func createDefaultChain(chainName string, tableName string) error {
-	ipt, err := iptables.New()
+func createDefaultChain(chainName string, tableName string, proto iptables.Protocol) error {
+	ipt, err := iptables.NewWithProtocol(proto)
 	if err != nil {
 		return err
 	}
@@ -82,8 +120,8 @@ func createDefaultChain(chainName string, tableName string) error {
 	}
 	return nil
 }
-func addDefaultChainIptableRule(chainName string, tableName string, logFilePath string, verbose bool) error {
-	ipt, err := iptables.New()
+func addDefaultChainIptableRule(chainName string, tableName string, proto iptables.Protocol, logFilePath string, verbose bool) error {
+	ipt, err := iptables.NewWithProtocol(proto)
 	if err != nil {
 		return err
 	}
@@ -98,8 +136,8 @@ func addDefaultChainIptableRule(chainName string, tableName string, logFilePath
 	return nil
 }
 
-func addIptableRule(rule models.Rule, setName string, chainName string, logFilePath string, verbose bool) error {
-	ipt, err := iptables.New()
+func addIptableRule(rule models.Rule, setName string, setType string, chainName string, proto iptables.Protocol, logFilePath string, verbose bool) error {
+	ipt, err := iptables.NewWithProtocol(proto)
 	if err != nil {
 		return err
 	}
@@ -110,11 +148,11 @@ func addIptableRule(rule models.Rule, setName string, chainName string, logFileP
 		rule.Type = append(rule.Type, "src")
 	}
 	for _, ruleType := range rule.Type {
-
+		flags := matchFlags(setType, ruleType)
 		if rule.Not {
-			err = ipt.InsertUnique(rule.Table, chainName, rule.Insert, "-m", "set", "!", "--match-set", setName, ruleType, "-j", rulePolicy)
+			err = ipt.InsertUnique(rule.Table, chainName, rule.Insert, "-m", "set", "!", "--match-set", setName, flags, "-j", rulePolicy)
 		} else {
-			err = ipt.InsertUnique(rule.Table, chainName, rule.Insert, "-m", "set", "--match-set", setName, ruleType, "-j", rulePolicy)
+			err = ipt.InsertUnique(rule.Table, chainName, rule.Insert, "-m", "set", "--match-set", setName, flags, "-j", rulePolicy)
 		}
 		logger.Log("Adding iptables rule to chain "+chainName+" and set "+setName, logFilePath, verbose)
 		// err = ipt.InsertUnique(rule.Table, chainName, rule.Insert)
@@ -126,10 +164,10 @@ func addIptableRule(rule models.Rule, setName string, chainName string, logFileP
 	return nil
 }
 
-func removeIptableRule(rule models.Rule, setName string, chainName string, logFilePath string, verbose bool, clear bool) error {
+func removeIptableRule(rule models.Rule, setName string, setType string, chainName string, proto iptables.Protocol, logFilePath string, verbose bool, clear bool) error {
 	var actions []string
 	actions = []string{"DROP", "ACCEPT"}
-	ipt, err := iptables.New()
+	ipt, err := iptables.NewWithProtocol(proto)
 	if err != nil {
 		return err
 	}
@@ -141,11 +179,12 @@ func removeIptableRule(rule models.Rule, setName string, chainName string, logFi
 	logger.Log("Removing iptables rule to chain "+chainName+" and set "+setName, logFilePath, verbose)
 
 	for _, ruleType := range rule.Type {
+		flags := matchFlags(setType, ruleType)
 		for _, terminateAction := range actions {
 			if rule.Not {
-				err = ipt.Delete(rule.Table, chainName, "-m", "set", "!", "--match-set", setName, ruleType, "-j", terminateAction)
+				err = ipt.Delete(rule.Table, chainName, "-m", "set", "!", "--match-set", setName, flags, "-j", terminateAction)
 			} else {
-				err = ipt.Delete(rule.Table, chainName, "-m", "set", "--match-set", setName, ruleType, "-j", terminateAction)
+				err = ipt.Delete(rule.Table, chainName, "-m", "set", "--match-set", setName, flags, "-j", terminateAction)
 			}
 			if err != nil {
 				if strings.Contains(err.Error(), "does a matching rule exist in that chain") {
@@ -188,85 +227,264 @@ func includeExtraIPs(ipList []string, extraIPs []string) []string {
 	}
 	return ipList
 }
-func parseIPAndCIDR(ip string) (net.IP, uint8) {
-	parsedIP, _, _ := net.ParseCIDR(ip)
-	cidr := strings.Split(string(ip), "/")
-	cidrInt, _ := strconv.Atoi(cidr[1])
-	cidr8 := uint8(cidrInt)
-	return parsedIP, cidr8
+
+// convertIPToEntry parses an element of a set of setType, e.g. 10.0.0.0/8
+// for hash:net or 10.0.0.0/8,tcp:22 for hash:net,port
+func convertIPToEntry(element string, setType string) (ipset.Entry, error) {
+	return parseElement(setType, element)
 }
 
-func convertIPToEntry(ip string) ipset.Entry {
-	ipNet, cidr := parseIPAndCIDR(ip)
-	entry := ipset.Entry{
-		IP:   ipNet,
-		CIDR: cidr,
-	}
-	return entry
+func setExists(setName string) bool {
+	_, err := ipsetnl.Header(setName)
+	return err == nil
 }
+
 func RollbackSet(setName string) {
-	backupSetName := setName + "-bak"
-	err := ipset.Swap(backupSetName, setName)
-	checkerr.Fatal(err)
-	fmt.Println("Successfully rolled back set " + setName + " with backup set " + backupSetName)
+	for _, f := range families {
+		name := setName + f.setSuffix
+		backupSetName := name + "-bak"
+		if !setExists(name) && f.setSuffix != "" {
+			// Sets created before IPv6 support have no inet6 counterpart
+			continue
+		}
+		backup, err := ipsetnl.Header(backupSetName)
+		if err != nil {
+			checkerr.Fatal(errors.New("set " + name + " has no backup set " + backupSetName))
+		}
+		live, err := ipsetnl.Header(name)
+		checkerr.Fatal(err)
+		// Backups are destroyed when a set changes type, but sets of
+		// different types can't be swapped anyway
+		if live.TypeName != backup.TypeName {
+			checkerr.Fatal(fmt.Errorf("cannot roll back set %s: it's a %s set and its backup %s a %s set",
+				name, live.TypeName, backupSetName, backup.TypeName))
+		}
+		err = ipset.Swap(backupSetName, name)
+		checkerr.Fatal(err)
+		fmt.Println("Successfully rolled back set " + name + " with backup set " + backupSetName)
+	}
+}
+
+func printSet(set *ipset.Sets, verbose bool) {
+	fmt.Printf("Set Name: %v\n", set.SetName)
+	fmt.Printf("Type: %v\n", set.TypeName)
+	fmt.Printf("Family: %v\n", familyName(set.Family))
+	fmt.Printf("Entries: %v\n", set.NumEntries)
+	fmt.Printf("References: %v\n", set.References)
+	fmt.Printf("Max elements: %v\n", set.MaxElements)
+	fmt.Printf("Hash size: %v\n", set.HashSize)
+	if set.Timeout != nil && *set.Timeout > 0 {
+		fmt.Printf("Timeout: %vs\n", *set.Timeout)
+	}
+	if extensions := setExtensions(set.CadtFlags); len(extensions) > 0 {
+		fmt.Printf("Extensions: %v\n", strings.Join(extensions, ", "))
+	}
+	bans, err := setBans(set.SetName)
+	if err != nil {
+		fmt.Println("WARNING: Could not read bans: " + err.Error())
+	}
+	if len(bans) > 0 {
+		fmt.Printf("Bans: %v\n", len(bans))
+	}
+	if verbose {
+		isBan := map[string]bool{}
+		for _, b := range bans {
+			isBan[b.Element] = true
+		}
+		fmt.Printf("\nEntries list:\n")
+		for _, entry := range set.Entries {
+			element := formatEntry(set.TypeName, entry)
+			line := element
+			if isBan[element] {
+				line += " ban"
+			}
+			if entry.Timeout != nil && *entry.Timeout > 0 {
+				line += " expires in " + (time.Duration(*entry.Timeout) * time.Second).String()
+			}
+			if entry.Packets != nil && entry.Bytes != nil {
+				line += fmt.Sprintf(" packets %d bytes %d", *entry.Packets, *entry.Bytes)
+			}
+			if entry.Comment != "" {
+				line += fmt.Sprintf(" comment %q", entry.Comment)
+			}
+			fmt.Println(line)
+		}
+	}
+	fmt.Println()
 }
 
 func ListAllSets(verbose bool) {
-	sets, err := ipset.ListAll()
+	sets, err := ipsetnl.ListAll()
 	checkerr.Fatal(err)
 	for _, s := range sets {
 		if s.NumEntries == 0 {
 			continue
 		}
-		set, err := ipset.List(s.SetName)
+		set, err := ipsetnl.List(s.SetName)
 		checkerr.Fatal(err)
-		fmt.Printf("Set Name: %v\n", set.SetName)
-		fmt.Printf("Entries: %v\n", set.NumEntries)
-		fmt.Printf("References: %v\n", set.References)
-		if verbose {
-			fmt.Printf("\nEntries list:\n")
-			for _, entry := range set.Entries {
-				fmt.Println(entry.IP.String() + "/" + strconv.Itoa(int(entry.CIDR)))
+		printSet(set, verbose)
+	}
+}
+
+func ListSet(setName string, verbose bool) {
+	for _, f := range families {
+		name := setName + f.setSuffix
+		set, err := ipsetnl.List(name)
+		if err != nil {
+			if !strings.Contains(err.Error(), "no such file or directory") {
+				checkerr.Fatal(err)
 			}
+			fmt.Println("Set " + name + " does not exist")
+			continue
 		}
-		fmt.Println()
+		printSet(set, verbose)
 	}
 }
 
-func ListSet(setName string, verbose bool) {
-	set, err := ipset.List(setName)
+// swapInSet fills a temporary set of the given family with the elements of
+// set and swaps it with setName, keeping the previous content in the backup
+// set. The live set is left untouched if any element can't be added. Bans
+// of setName are added too, with the time they have left.
+// With a timeout, entries expire after that many seconds unless they're added again.
+func swapInSet(elements []string, setName string, set models.Set, f family, logFilePath string, verbose bool) error {
+	tmpSetName := setName + "-tmp"
+	backupSetName := setName + "-bak"
+	setType := setTypeOf(set)
+	banElements, bans := banEntries(setName, setType, logFilePath, verbose)
+	options, maxElem, err := createOptions(set, f, len(elements)+len(bans))
 	if err != nil {
-		if !strings.Contains(err.Error(), "no such file or directory") {
-			checkerr.Fatal(err)
+		return err
+	}
+
+	entries := make([]ipset.Entry, len(elements))
+	for i, element := range elements {
+		entry, err := setEntry(set, element)
+		if err != nil {
+			return err
 		}
-		fmt.Println("Set " + setName + " does not exist")
-		return
+		entries[i] = entry
 	}
-	fmt.Printf("Set Name: %v\n", set.SetName)
-	fmt.Printf("Entries: %v\n", set.NumEntries)
-	fmt.Printf("References: %v\n", set.References)
-	if verbose {
-		fmt.Printf("\nEntries list:\n")
-		for _, entry := range set.Entries {
-			fmt.Println(entry.IP.String() + "/" + strconv.Itoa(int(entry.CIDR)))
+
+	// Create a temporary set with new IP pool that we'll swap it with old set later.
+	// A temporary set left over by an interrupted run may be of another type.
+	ipset.ForceDestroy(tmpSetName)
+	err = ipsetnl.Create(tmpSetName, setType, options, maxElem)
+	if err != nil {
+		return fmt.Errorf("could not create set %s: %w", tmpSetName, err)
+	}
+	if !setExists(setName) {
+		err = ipsetnl.Create(setName, setType, options, maxElem)
+		if err != nil {
+			ipset.Destroy(tmpSetName)
+			return fmt.Errorf("could not create set %s: %w", setName, err)
 		}
 	}
-	fmt.Println()
 
+	err = addEntries(tmpSetName, elements, entries, logFilePath, verbose)
+	if err == nil {
+		err = addBanEntries(tmpSetName, banElements, bans, set.Options.Comment, logFilePath, verbose)
+	}
+	if err != nil {
+		ipset.Destroy(tmpSetName)
+		return err
+	}
+
+	// A backup of another type is left over from before the set changed type
+	backup, err := ipsetnl.Header(backupSetName)
+	if err == nil && backup.TypeName != setType {
+		ipset.ForceDestroy(backupSetName)
+		backup = nil
+	}
+	if backup == nil {
+		err = ipsetnl.Create(backupSetName, setType, options, maxElem)
+		if err == nil {
+			// The entries were logged when filling the temporary set
+			err = addEntries(backupSetName, elements, entries, "", false)
+		}
+		if err != nil {
+			ipset.ForceDestroy(backupSetName)
+			ipset.Destroy(tmpSetName)
+			return err
+		}
+		err = ipset.Swap(tmpSetName, setName)
+		if err != nil {
+			return fmt.Errorf("could not swap in set %s, the new list is kept in %s: %w", setName, tmpSetName, err)
+		}
+	} else {
+		err = ipset.Swap(setName, backupSetName)
+		if err != nil {
+			return fmt.Errorf("could not back up set %s, the new list is kept in %s: %w", setName, tmpSetName, err)
+		}
+		err = ipset.Swap(tmpSetName, setName)
+		if err != nil {
+			// Put the live set back, the backup holds it after the first swap
+			if rollbackErr := ipset.Swap(setName, backupSetName); rollbackErr != nil {
+				return fmt.Errorf("could not swap in set %s: %v, nor restore it from %s: %w",
+					setName, err, backupSetName, rollbackErr)
+			}
+			return fmt.Errorf("could not swap in set %s, the new list is kept in %s: %w", setName, tmpSetName, err)
+		}
+	}
+	return ipset.Destroy(tmpSetName)
+}
+
+// dropChangedSet destroys setName and its backup if they aren't of setType,
+// since a set can only be swapped with a set of the same type. The iptables
+// rules matching the old set are removed first if ipsetfw manages them.
+func dropChangedSet(setName string, setType string, iptables bool, rule models.Rule, chainName string, f family,
+	logFilePath string, verbose bool) error {
+	header, err := ipsetnl.Header(setName)
+	if err != nil || header.TypeName == setType {
+		return nil
+	}
+	logger.Log("Set "+setName+" changes type from "+header.TypeName+" to "+setType+", recreating it without backup",
+		logFilePath, true)
+	if iptables {
+		err = removeIptableRule(rule, setName, header.TypeName, chainName, f.iptables, logFilePath, verbose, false)
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range []string{setName, setName + "-bak"} {
+		err = ipset.ForceDestroy(name)
+		if err != nil {
+			return fmt.Errorf("could not destroy %s set %s, is it used by other iptables rules? %w", header.TypeName, name, err)
+		}
+	}
+	return nil
+}
+
+// notify sends a notification to mattermost, logging instead of failing if it can't be delivered
+func notify(client *httpclient.Client, mattermost file.Mattermost, message string, logFilePath string, verbose bool) {
+	err := notif.SendNotificationMattermost(client, message, mattermost.URL, mattermost.Token)
+	if err != nil {
+		logger.Log("WARNING: Could not send mattermost notification: "+err.Error(), logFilePath, verbose)
+	}
 }
 
+// IPsetfw builds the sets of setModel from ipList and notifies mattermost of the outcome
 func IPsetfw(ipList []string, setModel models.Set, iptables bool, chainName string,
-	rule models.Rule, mattermost file.Mattermost, logFilePath string, verbose bool) error {
+	rule models.Rule, mattermost file.Mattermost, client *httpclient.Client, logFilePath string, verbose bool) error {
+	notifMsg, err := applySets(ipList, setModel, iptables, chainName, rule, logFilePath, verbose)
+	if notifMsg != "" {
+		notify(client, mattermost, notifMsg, logFilePath, verbose)
+	}
+	return err
+}
+
+// applySets builds the sets of setModel from ipList and returns the
+// notification to send about the outcome
+func applySets(ipList []string, setModel models.Set, iptables bool, chainName string,
+	rule models.Rule, logFilePath string, verbose bool) (string, error) {
 	usermgmt.ExitIfNotRoot()
 	var countryCode string
 	var setName string
 	var notifMsg string
-	var notifyMattermost bool = false
+	var setNames []string
+	var diffs []string
 
 	countryCode = setModel.Country
 	setName = setModel.SetName
-	tmpSetName := setName + "-tmp"
-	backupSetName := setName + "-bak"
 
 	if rule.Table == "" {
 		rule.Table = "raw"
@@ -275,100 +493,206 @@ func IPsetfw(ipList []string, setModel models.Set, iptables bool, chainName stri
 		rule.Chain = "IPSET_FW"
 	}
 
-	hostname, err := os.Hostname()
-	checkerr.Fatal(err)
-	currentTime := time.Now()
-	timeStampFormatted := currentTime.Format("2006-01-02 15:04:05")
-
-	if mattermost.Token != "" && mattermost.URL != "" {
-		notifyMattermost = true
-	}
+	var notifMsgInfo string = notifPrefix()
+	var err error
 
-	var notifMsgInfo string = timeStampFormatted + " HOST: " + hostname + " --- "
-	checkerr.Fatal(err)
+	ipListMerged := netutils.MergeIPsToCIDRs(ipList)
+	ipv4List, ipv6List := netutils.SplitByFamily(ipListMerged)
 
-	err = createDefaultChain(rule.Chain, rule.Table)
+	setType := setTypeOf(setModel)
+	err = validateSet(setModel)
 	if err != nil {
-		notifMsg = notifMsgInfo + "ERROR: Could not create chain " + chainName
-		if notifyMattermost {
-			notif.SendNotificationMattermost(notifMsg, mattermost.URL, mattermost.Token)
-		}
-		checkerr.Fatal(err)
+		return "", err
 	}
-
-	// Create a temporary set with new IP pool that we'll swap it with old set later
-	ipset.Create(tmpSetName, ipset.TypeHashNet, ipset.CreateOptions{})
-	ipset.Create(setName, ipset.TypeHashNet, ipset.CreateOptions{})
-
-	ipListMerged := netutils.MergeIPsToCIDRs(ipList)
-	for _, ip := range ipListMerged {
-		logger.Log("Adding "+ip, logFilePath, verbose)
-		entry := convertIPToEntry(ip)
-		ipset.Add(tmpSetName, &entry)
+	ipv4Elements, err := setElements(setModel, ipv4List)
+	if err != nil {
+		return "", err
+	}
+	ipv6Elements, err := setElements(setModel, ipv6List)
+	if err != nil {
+		return "", err
 	}
 
-	sets, _ := ipset.List(backupSetName)
-	if sets == nil {
-		ipset.Create(backupSetName, ipset.TypeHashNet, ipset.CreateOptions{})
-		for _, ip := range ipListMerged {
-			entry := convertIPToEntry(ip)
-			ipset.Add(backupSetName, &entry)
+	for _, f := range families {
+		familySetName := setName + f.setSuffix
+		familyElements := ipv4Elements
+		if f.ipset == ipset.FamilyIPV6 {
+			familyElements = ipv6Elements
 		}
-		ipset.Swap(tmpSetName, setName)
-	} else {
-		ipset.Swap(setName, backupSetName)
-		ipset.Swap(tmpSetName, setName)
-	}
-	err = ipset.Destroy(tmpSetName)
-	if err != nil {
-		notifMsg = notifMsgInfo + "ERROR: Could not destroy temporary set " + tmpSetName
-		if notifyMattermost {
-			notif.SendNotificationMattermost(notifMsg, mattermost.URL, mattermost.Token)
+
+		// ip6tables is only touched for rules with IPv6 entries that manage
+		// iptables rules
+		familyIptables := iptables
+		if f.ipset == ipset.FamilyIPV6 {
+			familyIptables = iptables && len(familyElements) > 0 && ip6tablesFound(logFilePath)
 		}
-		checkerr.Fatal(err)
-	}
-	if iptables {
-		err := addIptableRule(rule, setName, chainName, logFilePath, verbose)
+		if f.ipset == ipset.FamilyIPV4 || familyIptables {
+			err = createDefaultChain(rule.Chain, rule.Table, f.iptables)
+			if err != nil {
+				notifMsg = notifMsgInfo + "ERROR: Could not create " + f.name + " chain " + chainName
+				return notifMsg, err
+			}
+		}
+
+		err = dropChangedSet(familySetName, setType, familyIptables, rule, chainName, f, logFilePath, verbose)
 		if err != nil {
-			notifMsg = notifMsgInfo + "ERROR: Could not add rule for set: " + setName + " - chain: " + chainName
-			if notifyMattermost {
-				notif.SendNotificationMattermost(notifMsg, mattermost.URL, mattermost.Token)
+			notifMsg = notifMsgInfo + "ERROR: Could not change the type of set " + familySetName + " to " + setType
+			return notifMsg, err
+		}
+
+		var diff setDiff
+		diff, err = updateSet(familyElements, familySetName, setModel, f, logFilePath, verbose)
+		if err != nil {
+			notifMsg = notifMsgInfo + "ERROR: Could not update set " + familySetName + ": " + err.Error()
+			return notifMsg, err
+		}
+		if familyIptables {
+			err := addIptableRule(rule, familySetName, setType, chainName, f.iptables, logFilePath, verbose)
+			if err != nil {
+				notifMsg = notifMsgInfo + "ERROR: Could not add " + f.name + " rule for set: " + familySetName + " - chain: " + chainName
+				return notifMsg, err
 			}
-			checkerr.Fatal(err)
 		}
+		setNames = append(setNames, familySetName)
+		diffs = append(diffs, diff.String())
 	}
 
-	currentTime = time.Now()
-	timeStampFormatted = currentTime.Format("2006-01-02 15:04:05")
-
-	notifMsg = notifMsgInfo + "Successfully created set " + setName + " for country " +
-		countryCode + " with " + strconv.Itoa(len(ipList)) + " number of entries!"
+	countryLabel := " for country "
+	if strings.Contains(countryCode, ",") {
+		countryLabel = " for countries "
+	}
+	notifMsg = notifMsgInfo + "Successfully created sets " + strings.Join(setNames, ", ") + countryLabel +
+		countryCode + " with " + strconv.Itoa(len(ipv4Elements)) + " IPv4 and " +
+		strconv.Itoa(len(ipv6Elements)) + " IPv6 number of entries! Changes: " + strings.Join(diffs, ", ")
 
 	fmt.Printf(notifMsg + "\n")
-	notif.SendNotificationMattermost(notifMsg, mattermost.URL, mattermost.Token)
+	return notifMsg, nil
+}
+
+// liveEntries returns the number of entries in the sets of all families of
+// setName, not counting bans
+func liveEntries(setName string) int {
+	var entries int
+	for _, f := range families {
+		familySetName := setName + f.setSuffix
+		header, err := ipsetnl.Header(familySetName)
+		if err != nil {
+			continue
+		}
+		// Bans aren't part of the list, the new list is compared without them
+		_, bans := banEntries(familySetName, header.TypeName, "", false)
+		if live := int(header.NumEntries) - len(bans); live > 0 {
+			entries += live
+		}
+	}
+	return entries
+}
+
+// checkSafety compares the new list of a set with the live one and returns an
+// error if it violates any of the safety thresholds
+func checkSafety(newEntries int, setName string, safety models.Safety) error {
+	if safety.MinEntries > 0 && newEntries < safety.MinEntries {
+		return fmt.Errorf("new list has %d entries, less than minEntries %d", newEntries, safety.MinEntries)
+	}
+	return checkChange(newEntries, liveEntries(setName), safety)
+}
+
+// checkChange checks how much a list of newEntries entries changes a live set
+// of live entries against maxShrinkPercent and maxGrowthPercent. An empty or
+// missing live set can't be compared.
+func checkChange(newEntries int, live int, safety models.Safety) error {
+	if live == 0 {
+		return nil
+	}
+	change := float64(newEntries-live) / float64(live) * 100
+	if safety.MaxShrinkPercent > 0 && -change > safety.MaxShrinkPercent {
+		return fmt.Errorf("new list has %d entries, live set has %d: shrinks by %.1f%%, more than maxShrinkPercent %v",
+			newEntries, live, -change, safety.MaxShrinkPercent)
+	}
+	if safety.MaxGrowthPercent > 0 && change > safety.MaxGrowthPercent {
+		return fmt.Errorf("new list has %d entries, live set has %d: grows by %.1f%%, more than maxGrowthPercent %v",
+			newEntries, live, change, safety.MaxGrowthPercent)
+	}
 	return nil
 }
 
-func LoopConfigFile(path string, iptables bool, verbose bool) {
-	usermgmt.ExitIfNotRoot()
+func notifPrefix() string {
+	hostname, _ := os.Hostname()
+	return time.Now().Format("2006-01-02 15:04:05") + " HOST: " + hostname + " --- "
+}
+
+// configRun is a config file with its rules resolved, ready to be built
+type configRun struct {
+	inventory     file.Inventory
+	jobs          []ruleJob
+	order         []int
+	workers       int
+	sourceOptions netutils.SourceOptions
+	mattermost    file.Mattermost
+	client        *httpclient.Client
+}
+
+// loadConfig reads the config file and resolves the source, set and iptables
+// rule of every rule. With watch, dns rules are set up to be refreshed.
+func loadConfig(path string, iptables bool, verbose bool, watch bool) configRun {
 	configString := file.ReadConfigFile(path)
 	inventory := file.DecodeConfig(configString)
-	var ipListConcatenated []string
-	var ipList []string
-	var set models.Set
-	var rule models.Rule
 	var mattermost file.Mattermost
 	var logFilePath string = inventory.LogFilePath
 	if inventory.Mattermost.Token != "" && inventory.Mattermost.URL != "" {
 		mattermost = inventory.Mattermost
 	}
-
-	for _, r := range inventory.IPSetRules {
-		set = models.Set{
-			Country: r.Country,
-			SetName: r.SetName,
+	client, err := httpclient.New(inventory.HTTP, logFilePath, verbose)
+	checkerr.Fatal(err)
+	sourceOptions := netutils.SourceOptions{
+		Verbose:     verbose,
+		LogFilePath: logFilePath,
+		GeoDatabase: inventory.GeoDatabase,
+		ASNDatabase: inventory.ASNDatabase,
+		Cache:       inventory.Cache,
+		HTTP:        client,
+		Resolver:    resolver.New(inventory.DNS),
+	}
+	minRefresh := inventory.DNS.MinTTL
+	if minRefresh <= 0 {
+		minRefresh = DefaultMinTTL
+	}
+
+	jobs := make([]ruleJob, len(inventory.IPSetRules))
+	for i, r := range inventory.IPSetRules {
+		var compose *netutils.ComposeExpr
+		if r.Compose != "" {
+			compose, err = netutils.ParseCompose(r.Compose)
+			checkerr.Fatal(err)
 		}
-		rule = models.Rule{
+		sourceConfig := r.Source
+		if sourceConfig.Type == "" && compose == nil {
+			sourceConfig = netutils.LegacySource(r)
+		}
+		err = netutils.ExpandSourceCountries(&sourceConfig, inventory.Groups)
+		checkerr.Fatal(err)
+		if compose != nil && r.Verify != (models.Verify{}) {
+			checkerr.Fatal(fmt.Errorf("rule %s: verify: compose rules download no lists to verify", r.SetName))
+		}
+		if err := netutils.ValidateVerify(sourceConfig, sourceOptions); err != nil {
+			checkerr.Fatal(fmt.Errorf("rule %s: %w", r.SetName, err))
+		}
+		set := models.Set{
+			Country:    r.Country,
+			SetName:    r.SetName,
+			Type:       r.SetType,
+			Ports:      r.Ports,
+			Interfaces: r.Interfaces,
+			Options:    r.IPSet,
+			Timeout:    uint32(r.IPSet.Timeout / time.Second),
+		}
+		if len(sourceConfig.Countries) != 0 {
+			set.Country = strings.Join(sourceConfig.Countries, ", ")
+		}
+		if r.SetName != "" {
+			checkerr.Fatal(validateSet(set))
+		}
+		rule := models.Rule{
 			Policy: r.IPtables.Policy,
 			Insert: r.IPtables.Insert,
 			Type:   r.IPtables.Type,
@@ -379,21 +703,67 @@ func LoopConfigFile(path string, iptables bool, verbose bool) {
 		if r.IPtables.Policy != "" {
 			iptables = true
 		}
-		if len(r.Path) != 0 {
-			for _, path := range r.Path {
-				ipList = netutils.FetchIPPool(*&set.Country, verbose, path, logFilePath)
-				ipList = includeExtraIPs(ipList, r.ExtraIPs)
-				ipListConcatenated = append(ipListConcatenated, ipList...)
-			}
-			IPsetfw(ipListConcatenated, set, iptables, r.IPtables.Chain, rule, mattermost, logFilePath, verbose)
-		} else {
-			ipList := netutils.FetchIPPool(*&set.Country, verbose, "", logFilePath)
-			ipList = includeExtraIPs(ipList, r.ExtraIPs)
-			IPsetfw(ipList, set, iptables, r.IPtables.Chain, rule, mattermost, logFilePath, verbose)
+		name := r.Name
+		if name == "" {
+			name = r.SetName
 		}
+		set.Comment = name
+		set.Incremental = inventory.Incremental
+		jobs[i] = ruleJob{
+			name:     name,
+			config:   r,
+			compose:  compose,
+			source:   sourceConfig,
+			set:      set,
+			rule:     rule,
+			iptables: iptables,
+		}
+		if watch && sourceConfig.Type == "dns" && r.SetName != "" {
+			jobs[i].minRefresh = minRefresh
+		}
+	}
+
+	workers := inventory.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	order, err := composeOrder(jobs)
+	checkerr.Fatal(err)
+	return configRun{
+		inventory:     inventory,
+		jobs:          jobs,
+		order:         order,
+		workers:       workers,
+		sourceOptions: sourceOptions,
+		mattermost:    mattermost,
+		client:        client,
 	}
 }
 
+// buildConfig builds the sets of all rules of run and returns their results
+// in the order of the config file
+func buildConfig(run configRun, force bool, verbose bool) []ruleResult {
+	lists := map[string]*ruleList{}
+	for _, job := range run.jobs {
+		lists[job.name] = &ruleList{done: make(chan struct{})}
+	}
+	return runRules(run.jobs, run.order, run.workers, func(job ruleJob) ruleResult {
+		return buildRule(job, lists, run.inventory.Cache, run.sourceOptions, run.mattermost, run.client,
+			force, run.inventory.LogFilePath, verbose)
+	})
+}
+
+// LoopConfigFile builds the sets of all rules of the config file. Lists are
+// fetched and merged by up to workers rules at a time, composed rules after
+// the rules they reference, while changes to the kernel are applied one rule
+// at a time. It returns an error if any rule failed.
+func LoopConfigFile(path string, iptables bool, verbose bool, force bool) error {
+	usermgmt.ExitIfNotRoot()
+	run := loadConfig(path, iptables, verbose, false)
+	results := buildConfig(run, force, verbose)
+	return printSummary(results, run.inventory.LogFilePath)
+}
+
 func LoopConfigFileClear(path string, iptables bool, verbose bool) error {
 	usermgmt.ExitIfNotRoot()
 	configString := file.ReadConfigFile(path)
@@ -409,6 +779,10 @@ func LoopConfigFileClear(path string, iptables bool, verbose bool) error {
 		if r.IPtables.Policy != "" {
 			iptables = true
 		}
+		// Rules without a set are only used by compose expressions
+		if setName == "" {
+			continue
+		}
 		if rule.Table == "" {
 			rule.Table = "raw"
 		}
@@ -416,35 +790,55 @@ func LoopConfigFileClear(path string, iptables bool, verbose bool) error {
 			rule.Chain = "IPSET_FW"
 		}
 
-		err := createDefaultChain(rule.Chain, rule.Table)
-		if err != nil {
-			return err
-		}
-		if iptables {
-			err := removeIptableRule(rule, setName, rule.Chain, "", verbose, true)
-			if err != nil {
-				return err
+		for _, f := range families {
+			familySetName := setName + f.setSuffix
+			familyIptables := iptables
+			if f.ipset == ipset.FamilyIPV6 {
+				familyIptables = iptables && ip6tablesFound(inventory.LogFilePath)
 			}
-			time.Sleep(100 * time.Millisecond)
-		}
-		// Destroy set if it exists
-		err = ipset.Destroy(setName)
-		err = ipset.Destroy(setName + "-bak")
-		if err != nil {
-			if !strings.Contains(err.Error(), "The set with the given name does not exist") {
+			if f.ipset == ipset.FamilyIPV4 || familyIptables {
+				err := createDefaultChain(rule.Chain, rule.Table, f.iptables)
+				if err != nil {
+					return err
+				}
+			}
+			if familyIptables {
+				// The match flags depend on the type of the live set
+				setType := r.SetType
+				if header, err := ipsetnl.Header(familySetName); err == nil {
+					setType = header.TypeName
+				}
+				err := removeIptableRule(rule, familySetName, setType, rule.Chain, f.iptables, "", verbose, true)
 				if err != nil {
 					return err
 				}
+				time.Sleep(100 * time.Millisecond)
+			}
+			// Destroy set if it exists
+			for _, name := range []string{familySetName, familySetName + "-bak"} {
+				err := ipset.ForceDestroy(name)
+				if err != nil {
+					return err
+				}
+			}
+			err := dropBans(familySetName)
+			if err != nil {
+				return err
 			}
 		}
 	}
-	err := removeDefaultChainIptableRule(rule.Chain, rule.Table, "", verbose, true)
-	if err != nil {
-		return err
-	}
-	err = removeDefaultChain(rule.Chain, rule.Table, "", verbose)
-	if err != nil {
-		return err
+	for _, f := range families {
+		if f.ipset == ipset.FamilyIPV6 && !ip6tablesFound(inventory.LogFilePath) {
+			continue
+		}
+		err := removeDefaultChainIptableRule(rule.Chain, rule.Table, f.iptables, "", verbose, true)
+		if err != nil {
+			return err
+		}
+		err = removeDefaultChain(rule.Chain, rule.Table, f.iptables, "", verbose)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
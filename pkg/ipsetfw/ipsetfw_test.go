@@ -0,0 +1,42 @@
+package ipsetfw
+
+import (
+	"testing"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+)
+
+func TestCheckChange(t *testing.T) {
+	safety := models.Safety{MaxShrinkPercent: 50, MaxGrowthPercent: 100}
+	tests := []struct {
+		newEntries int
+		live       int
+		safety     models.Safety
+		ok         bool
+	}{
+		{100, 100, safety, true},
+		{50, 100, safety, true},
+		{49, 100, safety, false},
+		{200, 100, safety, true},
+		{201, 100, safety, false},
+		{0, 100, safety, false},
+		{0, 100, models.Safety{MaxGrowthPercent: 100}, true},
+		{1000, 100, models.Safety{MaxShrinkPercent: 50}, true},
+		// An empty or missing live set can't be compared
+		{1000, 0, safety, true},
+		{0, 0, safety, true},
+	}
+	for _, test := range tests {
+		err := checkChange(test.newEntries, test.live, test.safety)
+		if (err == nil) != test.ok {
+			t.Errorf("checkChange(%d, %d, %+v) = %v, want ok %v", test.newEntries, test.live, test.safety, err, test.ok)
+		}
+	}
+}
+
+func TestCheckSafetyMinEntries(t *testing.T) {
+	safety := models.Safety{MinEntries: 10}
+	if err := checkSafety(9, "", safety); err == nil {
+		t.Errorf("checkSafety(9, %+v) = nil, want an error", safety)
+	}
+}
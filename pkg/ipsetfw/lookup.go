@@ -0,0 +1,185 @@
+package ipsetfw
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/sabershahhoseini/ipset-firewall/util/file"
+	"github.com/sabershahhoseini/ipset-firewall/util/netutils"
+)
+
+// lookupMatch is a rule whose list contains a looked up address
+type lookupMatch struct {
+	Rule   string `json:"rule"`
+	Source string `json:"source"`
+	// Set is the set of the address' family, empty for rules without a set
+	Set    string `json:"set,omitempty"`
+	Prefix string `json:"prefix"`
+}
+
+type lookupResult struct {
+	Address string        `json:"address"`
+	Matches []lookupMatch `json:"matches"`
+	Error   string        `json:"error,omitempty"`
+}
+
+type lookupFailure struct {
+	Rule  string `json:"rule"`
+	Error string `json:"error"`
+}
+
+type lookupOutput struct {
+	Addresses []lookupResult `json:"addresses"`
+	// Failed are the rules whose list couldn't be fetched and wasn't searched
+	Failed []lookupFailure `json:"failed,omitempty"`
+}
+
+// ReadAddresses returns the addresses of the comma separated list value
+// and of the file at path, one per line with # comments. A path of "-"
+// reads the addresses from stdin.
+func ReadAddresses(value string, path string) ([]string, error) {
+	var addresses []string
+	for _, address := range strings.Split(value, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	if path == "" {
+		return addresses, nil
+	}
+	body, err := file.ReadListFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(body), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		if line = strings.TrimSpace(line); line != "" {
+			addresses = append(addresses, line)
+		}
+	}
+	return addresses, nil
+}
+
+// ruleSourceName describes where the list of a rule comes from
+func ruleSourceName(job ruleJob, sourceOptions netutils.SourceOptions) string {
+	if job.compose != nil {
+		return "compose: " + job.config.Compose
+	}
+	src, err := netutils.NewSource(job.source, sourceOptions)
+	if err != nil {
+		return job.source.Type
+	}
+	return src.Name()
+}
+
+// inExtraIPs reports whether addr was added to a rule by its extraIPs
+func inExtraIPs(addr netip.Addr, extraIPs []string) bool {
+	for _, ip := range extraIPs {
+		if prefix, ok := netutils.ParsePrefix(ip); ok && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// LookupConfigFile prints which rules of the config file contain each of
+// addresses, with the source and set of the rule and the network matching
+// the address. The lists of all rules are fetched and indexed in a prefix
+// trie without changing any set. It returns an error if the list of any
+// rule couldn't be fetched.
+func LookupConfigFile(path string, addresses []string, jsonOutput bool, verbose bool) error {
+	// Logs would mix with the json printed to stdout
+	run := loadConfig(path, false, verbose && !jsonOutput, false)
+	logFilePath := run.inventory.LogFilePath
+
+	lists := map[string]*ruleList{}
+	for _, job := range run.jobs {
+		lists[job.name] = &ruleList{done: make(chan struct{})}
+	}
+	results := runRules(run.jobs, run.order, run.workers, func(job ruleJob) ruleResult {
+		ipList, _, err := publishRuleList(job, lists, run.sourceOptions, file.Mattermost{}, run.client,
+			logFilePath, run.sourceOptions.Verbose)
+		return ruleResult{name: job.name, entries: len(ipList), err: err}
+	})
+
+	var output lookupOutput
+	var trie netutils.PrefixTrie
+	for i, job := range run.jobs {
+		if results[i].err != nil {
+			output.Failed = append(output.Failed, lookupFailure{Rule: job.name, Error: results[i].err.Error()})
+			continue
+		}
+		for _, prefix := range netutils.PrefixesFromIPs(lists[job.name].ipList) {
+			trie.Insert(prefix, i)
+		}
+	}
+
+	for _, address := range addresses {
+		result := lookupResult{Address: address, Matches: []lookupMatch{}}
+		addr, err := netip.ParseAddr(address)
+		if err != nil {
+			result.Error = "invalid IP address"
+			output.Addresses = append(output.Addresses, result)
+			continue
+		}
+		addr = addr.Unmap()
+		for _, match := range trie.Lookup(addr) {
+			job := run.jobs[match.Value]
+			source := ruleSourceName(job, run.sourceOptions)
+			if inExtraIPs(addr, job.config.ExtraIPs) {
+				source = "extraIPs"
+			}
+			set := job.set.SetName
+			if set != "" && addr.Is6() {
+				set += families[1].setSuffix
+			}
+			result.Matches = append(result.Matches, lookupMatch{
+				Rule:   job.name,
+				Source: source,
+				Set:    set,
+				Prefix: match.Prefix.String(),
+			})
+		}
+		output.Addresses = append(output.Addresses, result)
+	}
+
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(output); err != nil {
+			return err
+		}
+	} else {
+		printLookup(output)
+	}
+	if len(output.Failed) > 0 {
+		return fmt.Errorf("%d of %d rules failed, their lists weren't searched", len(output.Failed), len(run.jobs))
+	}
+	return nil
+}
+
+func printLookup(output lookupOutput) {
+	for _, failure := range output.Failed {
+		fmt.Println("WARNING: Rule " + failure.Rule + " failed and wasn't searched: " + failure.Error)
+	}
+	for _, result := range output.Addresses {
+		switch {
+		case result.Error != "":
+			fmt.Println(result.Address + ": " + result.Error)
+		case len(result.Matches) == 0:
+			fmt.Println(result.Address + ": not in any rule")
+		default:
+			fmt.Println(result.Address + ":")
+			for _, match := range result.Matches {
+				line := "  rule " + match.Rule + " from " + match.Source
+				if match.Set != "" {
+					line += ", set " + match.Set
+				}
+				fmt.Println(line + ": " + match.Prefix)
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package ipsetfw
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+	"github.com/sabershahhoseini/ipset-firewall/util/ipsetnl"
+	"github.com/sabershahhoseini/ipset-firewall/util/logger"
+
+	"github.com/lrh3321/ipset-go"
+)
+
+const (
+	// DefaultMaxElem and DefaultHashSize are the kernel's defaults, sets of
+	// longer lists are sized to their list
+	DefaultMaxElem  = 65536
+	DefaultHashSize = 1024
+	// maxComment is the longest comment the kernel keeps
+	maxComment = 255
+)
+
+// validateOptions checks the create options of a set
+func validateOptions(set models.Set) error {
+	options := set.Options
+	if options.Timeout != 0 && options.Timeout < time.Second {
+		return errors.New("set " + set.SetName + ": ipset timeout must be at least 1s")
+	}
+	if options.Timeout/time.Second > time.Duration(^uint32(0)) {
+		return errors.New("set " + set.SetName + ": ipset timeout is too long")
+	}
+	return nil
+}
+
+// createOptions returns the create options and maxelem of a set of f holding
+// entries elements. maxelem and hashsize the config doesn't set grow with the
+// list, leaving room for it to grow by half, while a maxelem set by the
+// config that can't hold the list is an error.
+func createOptions(set models.Set, f family, entries int) (ipset.CreateOptions, uint32, error) {
+	options := ipset.CreateOptions{
+		Family:   f.ipset,
+		Size:     set.Options.HashSize,
+		Timeout:  set.Timeout,
+		Counters: set.Options.Counters,
+		Comments: set.Options.Comment,
+		Skbinfo:  set.Options.Skbinfo,
+	}
+	maxElem := set.Options.MaxElem
+	if maxElem == 0 {
+		maxElem = DefaultMaxElem
+		for int(maxElem) < entries+entries/2 {
+			maxElem *= 2
+		}
+	}
+	if int(maxElem) < entries {
+		return options, 0, fmt.Errorf("set %s%s would hold %d entries, more than its maxelem %d",
+			set.SetName, f.setSuffix, entries, maxElem)
+	}
+	if options.Size == 0 {
+		options.Size = DefaultHashSize
+		for int(options.Size) < entries/4 {
+			options.Size *= 2
+		}
+	}
+	return options, maxElem, nil
+}
+
+// setExtensions names the extensions in the cadt flags of a set
+func setExtensions(flags uint32) []string {
+	var extensions []string
+	if flags&ipset.IPSET_FLAG_WITH_COUNTERS != 0 {
+		extensions = append(extensions, "counters")
+	}
+	if flags&ipset.IPSET_FLAG_WITH_COMMENT != 0 {
+		extensions = append(extensions, "comment")
+	}
+	if flags&ipset.IPSET_FLAG_WITH_SKBINFO != 0 {
+		extensions = append(extensions, "skbinfo")
+	}
+	return extensions
+}
+
+// setEntry parses an element of set. Entries of sets with comments are
+// commented with the reference of their network, or with set.Comment.
+func setEntry(set models.Set, element string) (ipset.Entry, error) {
+	entry, err := convertIPToEntry(element, setTypeOf(set))
+	if err != nil || !set.Options.Comment {
+		return entry, err
+	}
+	network, _, _ := strings.Cut(element, ",")
+	comment, ok := set.References[network]
+	if !ok {
+		comment = set.Comment
+	}
+	if len(comment) > maxComment {
+		comment = comment[:maxComment]
+	}
+	entry.Comment = comment
+	return entry, nil
+}
+
+// addEntries adds entries to setName and checks that the kernel kept all of
+// them, instead of silently dropping entries once the set is full
+func addEntries(setName string, elements []string, entries []ipset.Entry, logFilePath string, verbose bool) error {
+	var added int
+	for i := range entries {
+		logger.Log("Adding "+elements[i], logFilePath, verbose)
+		err := ipsetnl.Add(setName, &entries[i])
+		switch {
+		case err == ipset.ErrEntryExist:
+			// The same element was listed twice, e.g. as port 22 and tcp:22
+			continue
+		case err == ipsetnl.ErrHashFull:
+			return fmt.Errorf("set %s is full after %d of %d entries, raise its maxelem", setName, added, len(entries))
+		case err != nil:
+			return fmt.Errorf("could not add %s to set %s: %w", elements[i], setName, err)
+		}
+		added++
+	}
+	header, err := ipsetnl.Header(setName)
+	if err != nil {
+		return err
+	}
+	if int(header.NumEntries) != added {
+		return fmt.Errorf("set %s holds %d entries instead of %d", setName, header.NumEntries, added)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package ipsetfw
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+
+	"github.com/lrh3321/ipset-go"
+)
+
+func TestCreateOptions(t *testing.T) {
+	tests := []struct {
+		options  models.IPSetOptions
+		entries  int
+		maxElem  uint32
+		hashSize uint32
+		ok       bool
+	}{
+		{models.IPSetOptions{}, 0, DefaultMaxElem, DefaultHashSize, true},
+		{models.IPSetOptions{}, 40000, DefaultMaxElem, 16384, true},
+		// Lists are left room to grow by half
+		{models.IPSetOptions{}, 50000, 2 * DefaultMaxElem, 16384, true},
+		{models.IPSetOptions{}, 300000, 8 * DefaultMaxElem, 131072, true},
+		{models.IPSetOptions{MaxElem: 1000, HashSize: 64}, 1000, 1000, 64, true},
+		{models.IPSetOptions{MaxElem: 1000}, 1001, 0, 0, false},
+		{models.IPSetOptions{HashSize: 4096}, 10, DefaultMaxElem, 4096, true},
+	}
+	for _, test := range tests {
+		set := models.Set{SetName: "block", Options: test.options}
+		options, maxElem, err := createOptions(set, families[0], test.entries)
+		if (err == nil) != test.ok {
+			t.Errorf("createOptions(%+v, %d) error = %v, want ok %v", test.options, test.entries, err, test.ok)
+			continue
+		}
+		if test.ok && (maxElem != test.maxElem || options.Size != test.hashSize) {
+			t.Errorf("createOptions(%+v, %d) = maxelem %d hashsize %d, want %d %d",
+				test.options, test.entries, maxElem, options.Size, test.maxElem, test.hashSize)
+		}
+	}
+
+	set := models.Set{SetName: "block", Timeout: 3600, Options: models.IPSetOptions{Counters: true, Comment: true}}
+	options, _, err := createOptions(set, families[1], 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Family != ipset.FamilyIPV6 || options.Timeout != 3600 || !options.Counters || !options.Comments || options.Skbinfo {
+		t.Errorf("createOptions(%+v) = %+v", set, options)
+	}
+	if _, _, err := createOptions(models.Set{SetName: "block", Options: models.IPSetOptions{MaxElem: 1}}, families[1], 2); err == nil ||
+		!strings.Contains(err.Error(), "block-v6") {
+		t.Errorf("createOptions error = %v, want it to name block-v6", err)
+	}
+}
+
+func TestValidateOptions(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		ok      bool
+	}{
+		{0, true},
+		{time.Second, true},
+		{24 * time.Hour, true},
+		{time.Millisecond, false},
+		{time.Duration(1<<32) * time.Second, false},
+	}
+	for _, test := range tests {
+		err := validateOptions(models.Set{SetName: "block", Options: models.IPSetOptions{Timeout: test.timeout}})
+		if (err == nil) != test.ok {
+			t.Errorf("validateOptions(timeout %v) = %v, want ok %v", test.timeout, err, test.ok)
+		}
+	}
+}
+
+func TestSetEntryComment(t *testing.T) {
+	set := models.Set{
+		Options:    models.IPSetOptions{Comment: true},
+		Comment:    "drop-block",
+		References: map[string]string{"192.0.2.0/24": "SBL123"},
+	}
+	tests := []struct {
+		element string
+		want    string
+	}{
+		{"192.0.2.0/24", "SBL123"},
+		{"198.51.100.0/24", "drop-block"},
+	}
+	for _, test := range tests {
+		entry, err := setEntry(set, test.element)
+		if err != nil || entry.Comment != test.want {
+			t.Errorf("setEntry(%s) comment = %q, %v, want %q", test.element, entry.Comment, err, test.want)
+		}
+	}
+
+	set.Comment = strings.Repeat("x", 300)
+	if entry, _ := setEntry(set, "198.51.100.0/24"); len(entry.Comment) != maxComment {
+		t.Errorf("setEntry comment has %d characters, want %d", len(entry.Comment), maxComment)
+	}
+	set.Options.Comment = false
+	if entry, _ := setEntry(set, "192.0.2.0/24"); entry.Comment != "" {
+		t.Errorf("setEntry of a set without comments = %q, want no comment", entry.Comment)
+	}
+}
+
+func TestSetExtensions(t *testing.T) {
+	flags := uint32(ipset.IPSET_FLAG_WITH_COUNTERS | ipset.IPSET_FLAG_WITH_SKBINFO)
+	if got := strings.Join(setExtensions(flags), " "); got != "counters skbinfo" {
+		t.Errorf("setExtensions(%#x) = %s, want counters skbinfo", flags, got)
+	}
+	if got := setExtensions(0); len(got) != 0 {
+		t.Errorf("setExtensions(0) = %v, want none", got)
+	}
+}
@@ -0,0 +1,353 @@
+package ipsetfw
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+	"github.com/sabershahhoseini/ipset-firewall/util/file"
+	"github.com/sabershahhoseini/ipset-firewall/util/httpclient"
+	"github.com/sabershahhoseini/ipset-firewall/util/logger"
+	"github.com/sabershahhoseini/ipset-firewall/util/netutils"
+)
+
+// DefaultWorkers is how many rules are fetched at the same time if the config doesn't set workers
+const DefaultWorkers = 4
+
+// kernelMutex serializes changes to sets and iptables rules
+var kernelMutex sync.Mutex
+
+const (
+	statusUpdated   = "updated"
+	statusUnchanged = "unchanged"
+	statusKept      = "kept old set"
+	statusFailed    = "failed"
+	// statusListOnly is the status of rules without a set, only used by compose expressions
+	statusListOnly = "list only"
+)
+
+// ruleJob is a rule of the config file with its source and iptables rule resolved
+type ruleJob struct {
+	name     string
+	config   file.Rule
+	source   models.Source
+	compose  *netutils.ComposeExpr
+	set      models.Set
+	rule     models.Rule
+	iptables bool
+	// minRefresh is the shortest refresh interval of dns rules in watch mode,
+	// 0 for rules that are built once
+	minRefresh time.Duration
+}
+
+// ruleResult is the outcome of building the set of a rule
+type ruleResult struct {
+	name    string
+	status  string
+	entries int
+	// ttl is the shortest TTL of the records of a dns rule
+	ttl uint32
+	err error
+}
+
+// listInfo is what the source of a rule told about its list
+type listInfo struct {
+	// ttl is the shortest TTL of the records of a dns rule
+	ttl uint32
+	// references are the reference IDs the list gave its networks, e.g. Spamhaus SBL ids
+	references map[netip.Prefix]string
+}
+
+// ruleList is the final list of a rule as seen by compose expressions
+// referencing it. It's filled in before done is closed.
+type ruleList struct {
+	ipList []string
+	err    error
+	done   chan struct{}
+}
+
+// composeOrder checks rule names and compose references and returns the
+// indexes of jobs ordered so that every rule comes after the rules it composes
+func composeOrder(jobs []ruleJob) ([]int, error) {
+	byName := map[string]int{}
+	for i, job := range jobs {
+		if job.name == "" {
+			return nil, fmt.Errorf("rule %d has neither a set nor a name", i+1)
+		}
+		if _, ok := byName[job.name]; ok {
+			return nil, errors.New("duplicate rule name " + job.name)
+		}
+		byName[job.name] = i
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(jobs))
+	order := make([]int, 0, len(jobs))
+	var path []string
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visited:
+			return nil
+		case visiting:
+			return errors.New("compose cycle: " + strings.Join(append(path, jobs[i].name), " -> "))
+		}
+		state[i] = visiting
+		path = append(path, jobs[i].name)
+		if jobs[i].compose != nil {
+			for _, name := range jobs[i].compose.Names() {
+				dep, ok := byName[name]
+				if !ok {
+					return errors.New("rule " + jobs[i].name + " composes unknown rule " + name)
+				}
+				if err := visit(dep); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+		order = append(order, i)
+		return nil
+	}
+	for i := range jobs {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// runRules runs build on the jobs in order with up to workers jobs at a time
+// and returns the results in the order of jobs
+func runRules(jobs []ruleJob, order []int, workers int, build func(ruleJob) ruleResult) []ruleResult {
+	results := make([]ruleResult, len(jobs))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(jobs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = build(jobs[i])
+			}
+		}()
+	}
+	for _, i := range order {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
+
+// composeList evaluates the compose expression of a rule once the lists it
+// references are ready
+func composeList(job ruleJob, lists map[string]*ruleList) ([]string, error) {
+	prefixes, err := job.compose.Eval(func(name string) ([]netip.Prefix, error) {
+		list := lists[name]
+		<-list.done
+		if list.err != nil {
+			return nil, errors.New("composed rule " + name + " failed")
+		}
+		return netutils.PrefixesFromIPs(list.ipList), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return netutils.IPsFromPrefixes(prefixes), nil
+}
+
+// ruleIPList fetches or composes the list of a rule and applies its extraIPs
+// and exceptions. It also returns the TTL and references of the fetched list.
+func ruleIPList(job ruleJob, lists map[string]*ruleList, sourceOptions netutils.SourceOptions,
+	mattermost file.Mattermost, client *httpclient.Client, logFilePath string, verbose bool) ([]string, listInfo, error) {
+	var ipList []string
+	var info listInfo
+	if job.compose != nil {
+		composed, err := composeList(job, lists)
+		if err != nil {
+			return nil, info, err
+		}
+		ipList = composed
+	} else {
+		sourceOptions.Notify = func(message string) {
+			notify(client, mattermost, notifPrefix()+"Set "+job.set.SetName+": "+message, logFilePath, verbose)
+		}
+		fetched, err := netutils.FetchSource(job.source, sourceOptions)
+		var verificationErr *netutils.VerificationError
+		if errors.As(err, &verificationErr) {
+			notifMsg := notifPrefix() + "ERROR: Refusing to update set " + job.set.SetName + ": " + err.Error()
+			notify(client, mattermost, notifMsg, logFilePath, verbose)
+		}
+		if err != nil {
+			return nil, info, err
+		}
+		ipList = fetched.IPList()
+		info = listInfo{ttl: fetched.TTL, references: fetched.References}
+	}
+
+	for _, ip := range job.config.ExtraIPs {
+		if _, ok := netutils.ParsePrefix(ip); !ok {
+			return nil, info, errors.New("invalid extraIPs entry " + ip)
+		}
+	}
+	except, err := netutils.ExceptPrefixes(job.config.ExceptIPs, job.config.ExceptFiles, sourceOptions)
+	if err != nil {
+		return nil, info, err
+	}
+	ipList = netutils.MergeIPsToCIDRs(includeExtraIPs(ipList, job.config.ExtraIPs))
+	return netutils.SubtractIPs(ipList, except), info, nil
+}
+
+// publishRuleList computes the list of a rule with ruleIPList and publishes
+// it to the rules composing it
+func publishRuleList(job ruleJob, lists map[string]*ruleList, sourceOptions netutils.SourceOptions,
+	mattermost file.Mattermost, client *httpclient.Client, logFilePath string, verbose bool) ([]string, listInfo, error) {
+	ipList, info, err := ruleIPList(job, lists, sourceOptions, mattermost, client, logFilePath, verbose)
+	own := lists[job.name]
+	own.ipList, own.err = ipList, err
+	close(own.done)
+	return ipList, info, err
+}
+
+// setReferences returns the references of the networks of ipList that the
+// source gave a reference, networks merged with others lose theirs
+func setReferences(ipList []string, references map[netip.Prefix]string) map[string]string {
+	if len(references) == 0 {
+		return nil
+	}
+	byNetwork := map[string]string{}
+	for _, ip := range ipList {
+		prefix, ok := netutils.ParsePrefix(ip)
+		if !ok {
+			continue
+		}
+		if reference, ok := references[prefix]; ok {
+			byNetwork[ip] = reference
+		}
+	}
+	return byNetwork
+}
+
+// buildRule computes the list of a rule, publishes it to the rules composing
+// it, then applies it to the kernel. The notification of the outcome is sent
+// once kernelMutex is released, so a slow mattermost doesn't hold up other rules.
+func buildRule(job ruleJob, lists map[string]*ruleList, cache models.Cache, sourceOptions netutils.SourceOptions,
+	mattermost file.Mattermost, client *httpclient.Client, force bool, logFilePath string, verbose bool) ruleResult {
+	setName := job.set.SetName
+	result := ruleResult{name: job.name, status: statusFailed}
+
+	ipList, info, err := publishRuleList(job, lists, sourceOptions, mattermost, client, logFilePath, verbose)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	result.entries = len(ipList)
+	result.ttl = info.ttl
+	if setName == "" {
+		result.status = statusListOnly
+		return result
+	}
+
+	result, notifMsg := applyRule(job, result, ipList, info, cache, force, logFilePath, verbose)
+	if notifMsg != "" {
+		notify(client, mattermost, notifMsg, logFilePath, verbose)
+	}
+	return result
+}
+
+// applyRule applies the list of a rule to the kernel while holding kernelMutex.
+// It returns the result and the notification to send about it, if any.
+func applyRule(job ruleJob, result ruleResult, ipList []string, info listInfo, cache models.Cache,
+	force bool, logFilePath string, verbose bool) (ruleResult, string) {
+	setName := job.set.SetName
+	kernelMutex.Lock()
+	defer kernelMutex.Unlock()
+
+	elements, err := setElements(job.set, ipList)
+	if err != nil {
+		result.err = err
+		return result, ""
+	}
+	extra := fmt.Sprintf("%v %+v", job.iptables, job.rule)
+	if setTypeOf(job.set) != DefaultSetType {
+		extra += fmt.Sprintf(" %s %v %v", job.set.Type, job.set.Ports, job.set.Interfaces)
+	}
+	if job.set.Options != (models.IPSetOptions{}) {
+		extra += fmt.Sprintf(" %+v", job.set.Options)
+	}
+	if job.set.Options.Comment {
+		job.set.References = setReferences(ipList, info.references)
+		extra += fmt.Sprint(" ", job.set.References)
+	}
+	if job.minRefresh > 0 {
+		job.set.Timeout = entryTimeout(refreshInterval(info.ttl, job.minRefresh))
+		extra += " expiring"
+	}
+	// Skip sets whose list and rule didn't change since they were last built.
+	// Expiring sets are always rebuilt as their entries may have timed out.
+	digest := netutils.ListDigest(ipList, extra)
+	if job.minRefresh == 0 && netutils.IsListApplied(cache, setName, digest) && setExists(setName) {
+		logger.Log("List of set "+setName+" is unchanged, skipping rebuild", logFilePath, verbose)
+		result.status = statusUnchanged
+		return result, ""
+	}
+	if !force {
+		err = checkSafety(len(elements), setName, job.config.Safety)
+		if err != nil {
+			notifMsg := notifPrefix() + "ERROR: Keeping old set " + setName + ": " + err.Error() + ". Use -force to apply anyway"
+			logger.Log(notifMsg, logFilePath, true)
+			result.status = statusKept
+			result.err = err
+			return result, notifMsg
+		}
+	}
+	notifMsg, err := applySets(ipList, job.set, job.iptables, job.config.IPtables.Chain, job.rule, logFilePath, verbose)
+	if err != nil {
+		result.err = err
+		return result, notifMsg
+	}
+	result.status = statusUpdated
+	err = netutils.MarkListApplied(cache, setName, digest)
+	if err != nil {
+		logger.Log("WARNING: Could not record applied list: "+err.Error(), logFilePath, verbose)
+	}
+	return result, notifMsg
+}
+
+// printSummary logs the outcome of every rule and returns an error if any of
+// them failed or kept its old set
+func printSummary(results []ruleResult, logFilePath string) error {
+	var failed, kept int
+	logger.Log("Summary:", logFilePath, true)
+	for _, result := range results {
+		line := "  " + result.name + ": " + result.status
+		if result.status == statusUpdated || result.status == statusListOnly {
+			line += ", " + strconv.Itoa(result.entries) + " entries"
+		}
+		if result.err != nil {
+			line += ": " + result.err.Error()
+		}
+		switch result.status {
+		case statusFailed:
+			failed++
+		case statusKept:
+			kept++
+		}
+		logger.Log(line, logFilePath, true)
+	}
+	if failed > 0 || kept > 0 {
+		return fmt.Errorf("%d of %d rules failed, %d kept their old set", failed, len(results), kept)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package ipsetfw
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sabershahhoseini/ipset-firewall/util/netutils"
+)
+
+func TestComposeOrder(t *testing.T) {
+	tests := []struct {
+		// rules are name=compose pairs, rules without compose are just a name
+		rules []string
+		want  string
+		err   string
+	}{
+		{rules: []string{"a", "b"}, want: "a b"},
+		{rules: []string{"c=a union b", "a", "b"}, want: "a b c"},
+		{rules: []string{"d=c subtract a", "c=a union b", "a", "b"}, want: "a b c d"},
+		{rules: []string{"a=b", "b=a"}, err: "compose cycle: a -> b -> a"},
+		{rules: []string{"a=a union b", "b"}, err: "compose cycle: a -> a"},
+		{rules: []string{"x", "a=b", "b=c", "c=a"}, err: "compose cycle: a -> b -> c -> a"},
+		{rules: []string{"a=b union missing", "b"}, err: "rule a composes unknown rule missing"},
+		{rules: []string{"a", "a"}, err: "duplicate rule name a"},
+		{rules: []string{""}, err: "rule 1 has neither a set nor a name"},
+	}
+	for _, test := range tests {
+		var jobs []ruleJob
+		for _, rule := range test.rules {
+			name, compose, found := strings.Cut(rule, "=")
+			job := ruleJob{name: name}
+			if found {
+				expr, err := netutils.ParseCompose(compose)
+				if err != nil {
+					t.Fatal(err)
+				}
+				job.compose = expr
+			}
+			jobs = append(jobs, job)
+		}
+		order, err := composeOrder(jobs)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("composeOrder(%q) error = %v, want %q", test.rules, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("composeOrder(%q): %v", test.rules, err)
+			continue
+		}
+		var names []string
+		for _, i := range order {
+			names = append(names, jobs[i].name)
+		}
+		if got := strings.Join(names, " "); got != test.want {
+			t.Errorf("composeOrder(%q) = %s, want %s", test.rules, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package ipsetfw
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+	"github.com/sabershahhoseini/ipset-firewall/util/netutils"
+
+	"github.com/lrh3321/ipset-go"
+)
+
+// Dimensions of set elements
+const (
+	dimIP    = "ip"
+	dimNet   = "net"
+	dimPort  = "port"
+	dimIface = "iface"
+)
+
+// DefaultSetType is the type of sets of rules without setType
+const DefaultSetType = ipset.TypeHashNet
+
+// setTypes maps the supported set types to the dimensions of their elements
+var setTypes = map[string][]string{
+	ipset.TypeHashIP:       {dimIP},
+	ipset.TypeHashNet:      {dimNet},
+	ipset.TypeHashIPPort:   {dimIP, dimPort},
+	ipset.TypeHashNetPort:  {dimNet, dimPort},
+	ipset.TypeHashNetIface: {dimNet, dimIface},
+}
+
+// maxHostBits limits the networks of hash:ip lists to 2^maxHostBits addresses
+const maxHostBits = 16
+
+// portProtocols are the protocols accepted in port elements
+var portProtocols = map[string]uint8{
+	"tcp":     6,
+	"udp":     17,
+	"sctp":    132,
+	"udplite": 136,
+}
+
+func setTypeOf(set models.Set) string {
+	if set.Type == "" {
+		return DefaultSetType
+	}
+	return set.Type
+}
+
+// hasDimension reports whether elements of setType have dimension dim
+func hasDimension(setType string, dim string) bool {
+	for _, d := range setTypes[setType] {
+		if d == dim {
+			return true
+		}
+	}
+	return false
+}
+
+// validateSet checks the type and options of a set and that ports and
+// interfaces are given exactly when the type needs them
+func validateSet(set models.Set) error {
+	setType := setTypeOf(set)
+	if _, ok := setTypes[setType]; !ok {
+		var names []string
+		for name := range setTypes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("set %s: unsupported setType %q, supported are %s", set.SetName, set.Type, strings.Join(names, ", "))
+	}
+	if hasDimension(setType, dimPort) != (len(set.Ports) > 0) {
+		return errors.New("set " + set.SetName + ": ports are required by, and only allowed with, port set types")
+	}
+	if hasDimension(setType, dimIface) != (len(set.Interfaces) > 0) {
+		return errors.New("set " + set.SetName + ": interfaces are required by, and only allowed with, iface set types")
+	}
+	for _, port := range set.Ports {
+		if _, err := expandPorts(port); err != nil {
+			return fmt.Errorf("set %s: %w", set.SetName, err)
+		}
+	}
+	for _, iface := range set.Interfaces {
+		if err := checkIface(iface); err != nil {
+			return fmt.Errorf("set %s: %w", set.SetName, err)
+		}
+	}
+	return validateOptions(set)
+}
+
+// expandPorts expands a port, e.g. 22, tcp:22, udp:53 or tcp:8000-8010,
+// to one proto:port element per port. The protocol defaults to tcp.
+func expandPorts(spec string) ([]string, error) {
+	proto, ports, ok := strings.Cut(spec, ":")
+	if !ok {
+		proto, ports = "tcp", spec
+	}
+	proto = strings.ToLower(proto)
+	if _, ok := portProtocols[proto]; !ok {
+		return nil, fmt.Errorf("invalid port %q: unsupported protocol %s", spec, proto)
+	}
+	from, to, isRange := strings.Cut(ports, "-")
+	if !isRange {
+		to = from
+	}
+	first, err := strconv.ParseUint(from, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", spec)
+	}
+	last, err := strconv.ParseUint(to, 10, 16)
+	if err != nil || last < first {
+		return nil, fmt.Errorf("invalid port %q", spec)
+	}
+	var elements []string
+	for port := first; port <= last; port++ {
+		elements = append(elements, proto+":"+strconv.FormatUint(port, 10))
+	}
+	return elements, nil
+}
+
+func checkIface(iface string) error {
+	if iface == "" || len(iface) >= 16 || strings.ContainsAny(iface, " ,/") {
+		return fmt.Errorf("invalid interface %q", iface)
+	}
+	return nil
+}
+
+// hostElements expands the networks of ipList to their addresses
+func hostElements(ipList []string) ([]string, error) {
+	var hosts []string
+	for _, ip := range ipList {
+		prefix, ok := netutils.ParsePrefix(ip)
+		if !ok {
+			return nil, errors.New("invalid entry " + ip)
+		}
+		if prefix.Addr().BitLen()-prefix.Bits() > maxHostBits {
+			return nil, fmt.Errorf("network %s has more than %d addresses, too many for a %s set, use %s",
+				ip, 1<<maxHostBits, ipset.TypeHashIP, ipset.TypeHashNet)
+		}
+		for addr := prefix.Addr(); prefix.Contains(addr); addr = addr.Next() {
+			hosts = append(hosts, addr.String())
+		}
+	}
+	return hosts, nil
+}
+
+// setElements turns the networks of ipList into the elements of set in
+// ipset syntax: addresses for hash:ip, and every network combined with
+// every port or interface for types with more dimensions.
+func setElements(set models.Set, ipList []string) ([]string, error) {
+	setType := setTypeOf(set)
+	elements := ipList
+	if hasDimension(setType, dimIP) {
+		var err error
+		elements, err = hostElements(ipList)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var suffixes []string
+	for _, port := range set.Ports {
+		ports, err := expandPorts(port)
+		if err != nil {
+			return nil, err
+		}
+		suffixes = append(suffixes, ports...)
+	}
+	suffixes = append(suffixes, set.Interfaces...)
+	if len(suffixes) == 0 {
+		return elements, nil
+	}
+	combined := make([]string, 0, len(elements)*len(suffixes))
+	for _, element := range elements {
+		for _, suffix := range suffixes {
+			combined = append(combined, element+","+suffix)
+		}
+	}
+	return combined, nil
+}
+
+// parseElement parses an element of a set of setType in ipset syntax, e.g.
+// 10.0.0.0/8, 10.0.0.1,tcp:22 or 10.0.0.0/8,eth0
+func parseElement(setType string, element string) (ipset.Entry, error) {
+	dims, ok := setTypes[setType]
+	if !ok {
+		return ipset.Entry{}, errors.New("unsupported set type " + setType)
+	}
+	parts := strings.Split(element, ",")
+	if len(parts) != len(dims) {
+		return ipset.Entry{}, fmt.Errorf("invalid %s element %q", setType, element)
+	}
+	var entry ipset.Entry
+	for i, dim := range dims {
+		part := strings.TrimSpace(parts[i])
+		switch dim {
+		case dimIP, dimNet:
+			prefix, ok := netutils.ParsePrefix(part)
+			if !ok || (dim == dimIP && prefix.Bits() != prefix.Addr().BitLen()) {
+				return ipset.Entry{}, fmt.Errorf("invalid %s element %q", setType, element)
+			}
+			entry.IP = net.IP(prefix.Addr().AsSlice())
+			if dim == dimNet {
+				entry.CIDR = uint8(prefix.Bits())
+			}
+		case dimPort:
+			ports, err := expandPorts(part)
+			if err != nil || len(ports) != 1 {
+				return ipset.Entry{}, fmt.Errorf("invalid %s element %q", setType, element)
+			}
+			proto, port, _ := strings.Cut(ports[0], ":")
+			protocol := portProtocols[proto]
+			number, _ := strconv.ParseUint(port, 10, 16)
+			port16 := uint16(number)
+			entry.Protocol = &protocol
+			entry.Port = &port16
+		case dimIface:
+			if err := checkIface(part); err != nil {
+				return ipset.Entry{}, err
+			}
+			entry.IFace = part
+		}
+	}
+	return entry, nil
+}
+
+// formatEntry formats an entry of a set of setType in ipset syntax
+func formatEntry(setType string, entry ipset.Entry) string {
+	dims, ok := setTypes[setType]
+	if !ok {
+		dims = []string{dimNet}
+	}
+	var parts []string
+	for _, dim := range dims {
+		switch dim {
+		case dimIP:
+			parts = append(parts, entry.IP.String())
+		case dimNet:
+			addr, _ := netip.AddrFromSlice(entry.IP)
+			cidr := int(entry.CIDR)
+			if cidr == 0 {
+				cidr = addr.Unmap().BitLen()
+			}
+			parts = append(parts, entry.IP.String()+"/"+strconv.Itoa(cidr))
+		case dimPort:
+			proto := "proto"
+			for name, number := range portProtocols {
+				if entry.Protocol != nil && *entry.Protocol == number {
+					proto = name
+				}
+			}
+			var port uint16
+			if entry.Port != nil {
+				port = *entry.Port
+			}
+			parts = append(parts, proto+":"+strconv.Itoa(int(port)))
+		case dimIface:
+			parts = append(parts, entry.IFace)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// matchFlags returns the --match-set flags of an iptables rule of type
+// ruleType, e.g. src or dst, for a set of setType. ruleType is used for the
+// address, ports match the destination port and interfaces the incoming
+// interface, unless ruleType gives the flags of all dimensions, e.g. src,src.
+func matchFlags(setType string, ruleType string) string {
+	dims, ok := setTypes[setType]
+	if !ok || strings.Contains(ruleType, ",") {
+		return ruleType
+	}
+	flags := []string{ruleType}
+	for _, dim := range dims[1:] {
+		switch dim {
+		case dimPort:
+			flags = append(flags, "dst")
+		case dimIface:
+			flags = append(flags, "src")
+		}
+	}
+	return strings.Join(flags, ",")
+}
@@ -0,0 +1,132 @@
+package ipsetfw
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+
+	"github.com/lrh3321/ipset-go"
+)
+
+func TestParseElement(t *testing.T) {
+	tests := []struct {
+		setType string
+		element string
+		want    string
+	}{
+		{ipset.TypeHashNet, "10.0.0.0/8", "10.0.0.0/8"},
+		{ipset.TypeHashNet, "192.0.2.1", "192.0.2.1/32"},
+		{ipset.TypeHashNet, "2001:db8::/32", "2001:db8::/32"},
+		{ipset.TypeHashIP, "192.0.2.1", "192.0.2.1"},
+		{ipset.TypeHashIP, "2001:db8::1", "2001:db8::1"},
+		{ipset.TypeHashIPPort, "192.0.2.1,tcp:22", "192.0.2.1,tcp:22"},
+		{ipset.TypeHashIPPort, "192.0.2.1, 53", "192.0.2.1,tcp:53"},
+		{ipset.TypeHashNetPort, "10.0.0.0/8,UDP:53", "10.0.0.0/8,udp:53"},
+		{ipset.TypeHashNetIface, "10.0.0.0/8,eth0", "10.0.0.0/8,eth0"},
+		{ipset.TypeHashNet, "10.0.0.0/8,tcp:22", ""},
+		{ipset.TypeHashIP, "192.0.2.0/24", ""},
+		{ipset.TypeHashIPPort, "192.0.2.1", ""},
+		{ipset.TypeHashIPPort, "192.0.2.1,icmp:0", ""},
+		{ipset.TypeHashIPPort, "192.0.2.1,tcp:22-23", ""},
+		{ipset.TypeHashNetIface, "10.0.0.0/8,eth 0", ""},
+		{ipset.TypeHashNet, "example.com", ""},
+		{"bitmap:port", "22", ""},
+	}
+	for _, test := range tests {
+		entry, err := parseElement(test.setType, test.element)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("parseElement(%s, %q) = %s, want an error", test.setType, test.element, formatEntry(test.setType, entry))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseElement(%s, %q): %v", test.setType, test.element, err)
+			continue
+		}
+		if got := formatEntry(test.setType, entry); got != test.want {
+			t.Errorf("parseElement(%s, %q) = %s, want %s", test.setType, test.element, got, test.want)
+		}
+	}
+}
+
+func TestSetElements(t *testing.T) {
+	tests := []struct {
+		set    models.Set
+		ipList string
+		want   string
+	}{
+		{models.Set{}, "10.0.0.0/8 192.0.2.1", "10.0.0.0/8 192.0.2.1"},
+		{models.Set{Type: ipset.TypeHashIP}, "192.0.2.0/30 2001:db8::1", "192.0.2.0 192.0.2.1 192.0.2.2 192.0.2.3 2001:db8::1"},
+		{models.Set{Type: ipset.TypeHashIPPort, Ports: []string{"22", "udp:53"}}, "192.0.2.1",
+			"192.0.2.1,tcp:22 192.0.2.1,udp:53"},
+		{models.Set{Type: ipset.TypeHashNetPort, Ports: []string{"tcp:8000-8002"}}, "10.0.0.0/8 192.0.2.0/24",
+			"10.0.0.0/8,tcp:8000 10.0.0.0/8,tcp:8001 10.0.0.0/8,tcp:8002 192.0.2.0/24,tcp:8000 192.0.2.0/24,tcp:8001 192.0.2.0/24,tcp:8002"},
+		{models.Set{Type: ipset.TypeHashNetIface, Interfaces: []string{"eth0", "eth1"}}, "10.0.0.0/8",
+			"10.0.0.0/8,eth0 10.0.0.0/8,eth1"},
+		{models.Set{}, "", ""},
+	}
+	for _, test := range tests {
+		got, err := setElements(test.set, strings.Fields(test.ipList))
+		if err != nil {
+			t.Errorf("setElements(%+v, %s): %v", test.set, test.ipList, err)
+			continue
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("setElements(%+v, %s) = %s, want %s", test.set, test.ipList, strings.Join(got, " "), test.want)
+		}
+	}
+
+	// hash:ip sets refuse networks too large to expand
+	if _, err := setElements(models.Set{Type: ipset.TypeHashIP}, []string{"10.0.0.0/8"}); err == nil {
+		t.Errorf("setElements of 10.0.0.0/8 in a hash:ip set succeeded")
+	}
+}
+
+func TestMatchFlags(t *testing.T) {
+	tests := []struct {
+		setType  string
+		ruleType string
+		want     string
+	}{
+		{ipset.TypeHashNet, "src", "src"},
+		{ipset.TypeHashIP, "dst", "dst"},
+		{ipset.TypeHashIPPort, "src", "src,dst"},
+		{ipset.TypeHashNetPort, "dst", "dst,dst"},
+		{ipset.TypeHashNetIface, "src", "src,src"},
+		{ipset.TypeHashNetIface, "dst", "dst,src"},
+		{ipset.TypeHashIPPort, "src,src", "src,src"},
+		{"unknown", "src", "src"},
+	}
+	for _, test := range tests {
+		if got := matchFlags(test.setType, test.ruleType); got != test.want {
+			t.Errorf("matchFlags(%s, %s) = %s, want %s", test.setType, test.ruleType, got, test.want)
+		}
+	}
+}
+
+func TestValidateSet(t *testing.T) {
+	tests := []struct {
+		set models.Set
+		ok  bool
+	}{
+		{models.Set{SetName: "a"}, true},
+		{models.Set{SetName: "a", Type: ipset.TypeHashIPPort, Ports: []string{"tcp:22", "udp:1000-2000"}}, true},
+		{models.Set{SetName: "a", Type: ipset.TypeHashNetIface, Interfaces: []string{"eth0"}}, true},
+		{models.Set{SetName: "a", Type: "bitmap:port"}, false},
+		{models.Set{SetName: "a", Type: ipset.TypeHashIPPort}, false},
+		{models.Set{SetName: "a", Ports: []string{"22"}}, false},
+		{models.Set{SetName: "a", Type: ipset.TypeHashNetIface}, false},
+		{models.Set{SetName: "a", Type: ipset.TypeHashIPPort, Ports: []string{"icmp:1"}}, false},
+		{models.Set{SetName: "a", Type: ipset.TypeHashIPPort, Ports: []string{"tcp:30-20"}}, false},
+		{models.Set{SetName: "a", Type: ipset.TypeHashIPPort, Ports: []string{"tcp:70000"}}, false},
+		{models.Set{SetName: "a", Type: ipset.TypeHashNetIface, Interfaces: []string{"a-very-long-interface"}}, false},
+	}
+	for _, test := range tests {
+		err := validateSet(test.set)
+		if (err == nil) != test.ok {
+			t.Errorf("validateSet(%+v) = %v, want ok %v", test.set, err, test.ok)
+		}
+	}
+}
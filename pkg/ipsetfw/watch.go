@@ -0,0 +1,152 @@
+package ipsetfw
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+	"github.com/sabershahhoseini/ipset-firewall/util/ipsetnl"
+	"github.com/sabershahhoseini/ipset-firewall/util/logger"
+	"github.com/sabershahhoseini/ipset-firewall/util/netutils"
+	"github.com/sabershahhoseini/ipset-firewall/util/usermgmt"
+
+	"github.com/lrh3321/ipset-go"
+)
+
+const (
+	// DefaultMinTTL is the shortest refresh interval of dns rules if the config doesn't set minTTL
+	DefaultMinTTL = 30 * time.Second
+	// maxRefresh caps the refresh interval of records with very long TTLs
+	maxRefresh = 24 * time.Hour
+	// refreshGrace is how long entries outlive their refresh interval, so a
+	// slow or failed refresh doesn't drop them right away
+	refreshGrace = time.Minute
+)
+
+// refreshInterval returns when a list whose records have ttl must be resolved again
+func refreshInterval(ttl uint32, minRefresh time.Duration) time.Duration {
+	interval := time.Duration(ttl) * time.Second
+	if interval < minRefresh {
+		interval = minRefresh
+	}
+	if interval > maxRefresh {
+		interval = maxRefresh
+	}
+	return interval
+}
+
+// entryTimeout returns the ipset timeout in seconds of entries refreshed every interval
+func entryTimeout(interval time.Duration) uint32 {
+	return uint32((interval + refreshGrace) / time.Second)
+}
+
+// refreshSet adds the elements of ipList to the live sets of set with
+// timeout, resetting the timeout of entries already in them. Entries no
+// longer in ipList are left to expire.
+func refreshSet(ipList []string, set models.Set, timeout uint32, logFilePath string, verbose bool) error {
+	kernelMutex.Lock()
+	defer kernelMutex.Unlock()
+
+	ipv4List, ipv6List := netutils.SplitByFamily(ipList)
+	for _, f := range families {
+		familySetName := set.SetName + f.setSuffix
+		familyIPList := ipv4List
+		if f.ipset == ipset.FamilyIPV6 {
+			familyIPList = ipv6List
+		}
+		elements, err := setElements(set, familyIPList)
+		if err != nil {
+			return err
+		}
+		for _, element := range elements {
+			logger.Log("Refreshing "+element+" in set "+familySetName, logFilePath, verbose)
+			entry, err := setEntry(set, element)
+			if err != nil {
+				return err
+			}
+			entry.Timeout = &timeout
+			entry.Replace = true
+			err = ipsetnl.Add(familySetName, &entry)
+			if err != nil {
+				return fmt.Errorf("could not add %s to set %s: %w", element, familySetName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// WatchConfigFile builds the sets of all rules like LoopConfigFile, then keeps
+// running and resolves the hosts of dns rules again whenever their records
+// expire. Addresses are added with a timeout a bit longer than the refresh
+// interval, so addresses a host no longer resolves to expire from the set.
+// Other rules, including rules composing dns rules, are built once.
+func WatchConfigFile(path string, iptables bool, verbose bool, force bool) error {
+	usermgmt.ExitIfNotRoot()
+	run := loadConfig(path, iptables, verbose, true)
+	logFilePath := run.inventory.LogFilePath
+	results := buildConfig(run, force, verbose)
+	err := printSummary(results, logFilePath)
+	if err != nil {
+		logger.Log("WARNING: "+err.Error(), logFilePath, true)
+	}
+
+	// next is when each watched rule is refreshed, built whether its set was
+	// created with expiring entries and can be refreshed in place
+	next := map[int]time.Time{}
+	built := map[int]bool{}
+	for i, job := range run.jobs {
+		if job.minRefresh == 0 {
+			continue
+		}
+		next[i] = time.Now().Add(refreshInterval(results[i].ttl, job.minRefresh))
+		built[i] = results[i].status == statusUpdated
+	}
+	if len(next) == 0 {
+		return errors.New("no rules with hosts to watch in " + path)
+	}
+
+	for {
+		i := -1
+		for j := range next {
+			if i == -1 || next[j].Before(next[i]) {
+				i = j
+			}
+		}
+		time.Sleep(time.Until(next[i]))
+		job := run.jobs[i]
+		setName := job.set.SetName
+
+		// Sets that failed to build are rebuilt from scratch
+		if !built[i] {
+			lists := map[string]*ruleList{job.name: {done: make(chan struct{})}}
+			result := buildRule(job, lists, run.inventory.Cache, run.sourceOptions, run.mattermost, run.client,
+				force, logFilePath, verbose)
+			built[i] = result.status == statusUpdated
+			if result.err != nil {
+				logger.Log("WARNING: Could not build set "+setName+": "+result.err.Error(), logFilePath, true)
+			}
+			next[i] = time.Now().Add(refreshInterval(result.ttl, job.minRefresh))
+			continue
+		}
+
+		ipList, info, err := ruleIPList(job, nil, run.sourceOptions, run.mattermost, run.client, logFilePath, verbose)
+		if err != nil {
+			logger.Log("WARNING: Could not refresh set "+setName+", keeping entries until they expire: "+err.Error(), logFilePath, true)
+			next[i] = time.Now().Add(job.minRefresh)
+			continue
+		}
+		interval := refreshInterval(info.ttl, job.minRefresh)
+		err = refreshSet(ipList, job.set, entryTimeout(interval), logFilePath, verbose)
+		if err != nil {
+			logger.Log("WARNING: Could not refresh set "+setName+", rebuilding it: "+err.Error(), logFilePath, true)
+			built[i] = false
+			next[i] = time.Now()
+			continue
+		}
+		logger.Log("Refreshed set "+setName+" with "+strconv.Itoa(len(ipList))+" entries, next refresh in "+interval.String(),
+			logFilePath, verbose)
+		next[i] = time.Now().Add(interval)
+	}
+}
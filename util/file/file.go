@@ -2,10 +2,13 @@ package file
 
 import (
 	"bufio"
+	"compress/gzip"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/sabershahhoseini/ipset-firewall/error/checkerr"
@@ -15,11 +18,36 @@ import (
 )
 
 type Rule struct {
-	Country  string      `yaml:"country"`
-	SetName  string      `yaml:"set"`
-	Path     []string    `yaml:"file"`
-	ExtraIPs []string    `yaml:"extraIPs"`
-	IPtables models.Rule `yaml:"iptables"`
+	// Name is how compose expressions refer to the rule, the set name by default
+	Name    string `yaml:"name"`
+	Country string `yaml:"country"`
+	// Countries are merged into one set together with Country, they may name country groups
+	Countries []string `yaml:"countries"`
+	SetName   string   `yaml:"set"`
+	// SetType is the ipset type of the set, e.g. hash:ip or hash:net,port
+	SetType string `yaml:"setType"`
+	// Ports and Interfaces complete the elements of port and iface set types
+	Ports      []string      `yaml:"ports"`
+	Interfaces []string      `yaml:"interfaces"`
+	Path       []string      `yaml:"file"`
+	URL        []string      `yaml:"url"`
+	Format     models.Format `yaml:"format"`
+	ASN        []string      `yaml:"asn"`
+	// Hosts are resolved and their addresses added to the set
+	Hosts    []string      `yaml:"hosts"`
+	Verify   models.Verify `yaml:"verify"`
+	Safety   models.Safety `yaml:",inline"`
+	ExtraIPs []string      `yaml:"extraIPs"`
+	// ExceptIPs and the networks in ExceptFiles are never members of the set
+	ExceptIPs   []string      `yaml:"exceptIPs"`
+	ExceptFiles []string      `yaml:"exceptFiles"`
+	IPtables    models.Rule   `yaml:"iptables"`
+	Source      models.Source `yaml:"source"`
+	// Compose builds the list from other rules' lists, e.g. "eu subtract de"
+	Compose string `yaml:"compose"`
+
+	// IPSet holds the create options of the set, e.g. maxelem or counters
+	IPSet models.IPSetOptions `yaml:"ipset"`
 }
 type Mattermost struct {
 	URL   string `yaml:"url"`
@@ -31,6 +59,20 @@ type Inventory struct {
 	IPSetRules  []Rule     `yaml:"rules"`
 	Mattermost  Mattermost `yaml:"mattermost"`
 	LogFilePath string     `yaml:"logFile"`
+	// GeoDatabase makes country rules read from a local database instead of github
+	GeoDatabase models.GeoDatabase `yaml:"geoDatabase"`
+	// ASNDatabase is a prefix to origin AS dump used by asn rules
+	ASNDatabase string       `yaml:"asnDatabase"`
+	Cache       models.Cache `yaml:"cache"`
+	HTTP        models.HTTP  `yaml:"http"`
+	// Workers limits how many rules are fetched at the same time
+	Workers int `yaml:"workers"`
+	// Groups are user-defined country groups usable in country and countries
+	Groups map[string][]string `yaml:"groups"`
+	DNS    models.DNS          `yaml:"dns"`
+	// Incremental updates live sets with the entries their list gained and
+	// lost instead of swapping in a rebuilt set
+	Incremental bool `yaml:"incremental"`
 }
 
 func ReadConfigFile(path string) string {
@@ -45,12 +87,99 @@ func ReadConfigFile(path string) string {
 	return buf.String()
 }
 
-func ReadListFile(file string) []string {
-	b, err := os.ReadFile(file)
-	checkerr.Fatal(err)
+// OpenList opens a list file, transparently decompressing gzip files
+func OpenList(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(f)
+	magic, _ := reader.Peek(2)
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &gzipFile{Reader: gz, file: f}, nil
+	}
+	return &bufferedFile{Reader: reader, file: f}, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipFile) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
+
+type bufferedFile struct {
+	*bufio.Reader
+	file *os.File
+}
 
-	ipList := strings.Split(string(b), "\n")
-	return ipList
+func (b *bufferedFile) Close() error {
+	return b.file.Close()
+}
+
+// ExpandListPaths expands globs and directories in a list of paths. A
+// directory stands for the regular files directly inside it, except hidden
+// ones. Matches are sorted; "-" is kept as is.
+func ExpandListPaths(paths []string) ([]string, error) {
+	var expanded []string
+	for _, path := range paths {
+		if path == "-" {
+			expanded = append(expanded, path)
+			continue
+		}
+		matches := []string{path}
+		if strings.ContainsAny(path, "*?[") {
+			var err error
+			matches, err = filepath.Glob(path)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			sort.Strings(matches)
+		}
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				expanded = append(expanded, match)
+				continue
+			}
+			entries, err := os.ReadDir(match)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				if strings.HasPrefix(entry.Name(), ".") || !entry.Type().IsRegular() {
+					continue
+				}
+				expanded = append(expanded, filepath.Join(match, entry.Name()))
+			}
+		}
+	}
+	return expanded, nil
+}
+
+// ReadListFile reads a list file, decompressing gzip files. A path of "-"
+// reads the list from stdin.
+func ReadListFile(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	f, err := OpenList(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
 }
 
 func DecodeConfig(config string) Inventory {
@@ -0,0 +1,107 @@
+package file
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadListFile(t *testing.T) {
+	list := "192.0.2.0/24\n# comment\n2001:db8::/32\n"
+	dir := t.TempDir()
+
+	plain := filepath.Join(dir, "list.txt")
+	if err := os.WriteFile(plain, []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte(list))
+	gz.Close()
+	// Gzip files are detected by their content, not their name
+	gzipped := filepath.Join(dir, "list.txt.gz")
+	if err := os.WriteFile(gzipped, compressed.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	renamed := filepath.Join(dir, "list.dat")
+	if err := os.WriteFile(renamed, compressed.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	short := filepath.Join(dir, "short.txt")
+	if err := os.WriteFile(short, []byte("1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{plain, gzipped, renamed} {
+		got, err := ReadListFile(path)
+		if err != nil {
+			t.Errorf("ReadListFile(%s): %v", path, err)
+			continue
+		}
+		if string(got) != list {
+			t.Errorf("ReadListFile(%s) = %q, want %q", path, got, list)
+		}
+	}
+	if got, err := ReadListFile(short); err != nil || string(got) != "1" {
+		t.Errorf("ReadListFile(%s) = %q, %v, want \"1\"", short, got, err)
+	}
+	if _, err := ReadListFile(filepath.Join(dir, "missing.txt")); err == nil {
+		t.Errorf("ReadListFile of a missing file succeeded")
+	}
+}
+
+func TestExpandListPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"lists/b.txt", "lists/a.txt", "lists/.hidden", "lists/sub/c.txt", "d.txt", "e.csv"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		paths []string
+		want  []string
+		ok    bool
+	}{
+		{[]string{"d.txt"}, []string{"d.txt"}, true},
+		{[]string{"-"}, []string{"-"}, true},
+		// Directories are read one level deep, without hidden files
+		{[]string{"lists"}, []string{"lists/a.txt", "lists/b.txt"}, true},
+		{[]string{"*.txt", "-"}, []string{"d.txt", "-"}, true},
+		{[]string{"*"}, []string{"d.txt", "e.csv", "lists/a.txt", "lists/b.txt"}, true},
+		{[]string{"lists/*.txt", "lists/sub"}, []string{"lists/a.txt", "lists/b.txt", "lists/sub/c.txt"}, true},
+		{[]string{"*.json"}, nil, true},
+		{[]string{"missing.txt"}, nil, false},
+		{[]string{"[.txt"}, nil, false},
+	}
+	for _, test := range tests {
+		var paths []string
+		for _, path := range test.paths {
+			if path != "-" {
+				path = filepath.Join(dir, path)
+			}
+			paths = append(paths, path)
+		}
+		got, err := ExpandListPaths(paths)
+		if (err == nil) != test.ok {
+			t.Errorf("ExpandListPaths(%v) error = %v, want ok %v", test.paths, err, test.ok)
+			continue
+		}
+		var rel []string
+		for _, path := range got {
+			if path != "-" {
+				path, _ = filepath.Rel(dir, path)
+			}
+			rel = append(rel, path)
+		}
+		if strings.Join(rel, " ") != strings.Join(test.want, " ") {
+			t.Errorf("ExpandListPaths(%v) = %v, want %v", test.paths, rel, test.want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Package httpclient provides the HTTP client shared by list sources and
+// notifiers, with timeouts, proxies, retries and mirrors.
+package httpclient
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+	"github.com/sabershahhoseini/ipset-firewall/util/logger"
+)
+
+const (
+	DefaultTimeout   = 30 * time.Second
+	DefaultBackoff   = time.Second
+	DefaultUserAgent = "ipset-firewall"
+	// maxBackoff caps the delay between two retries
+	maxBackoff = time.Minute
+)
+
+type Client struct {
+	client      *http.Client
+	config      models.HTTP
+	logFilePath string
+	verbose     bool
+}
+
+// defaultClient is used by a nil *Client
+var defaultClient, _ = New(models.HTTP{}, "", false)
+
+// New builds a client from the http block of the config
+func New(config models.HTTP, logFilePath string, verbose bool) (*Client, error) {
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.Backoff == 0 {
+		config.Backoff = DefaultBackoff
+	}
+	if config.UserAgent == "" {
+		config.UserAgent = DefaultUserAgent
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.Proxy != "" {
+		proxyURL, err := url.Parse(config.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %q: %v", config.Proxy, err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("invalid proxy %q: scheme must be http, https or socks5", config.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if config.CABundle != "" {
+		pem, err := os.ReadFile(config.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in CA bundle " + config.CABundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &Client{
+		client:      &http.Client{Timeout: config.Timeout, Transport: transport},
+		config:      config,
+		logFilePath: logFilePath,
+		verbose:     verbose,
+	}, nil
+}
+
+// mirrorURLs returns rawURL followed by its mirrors, using the longest
+// configured prefix it starts with
+func (c *Client) mirrorURLs(rawURL string) []string {
+	var prefix string
+	for p := range c.config.Mirrors {
+		if strings.HasPrefix(rawURL, p) && len(p) > len(prefix) {
+			prefix = p
+		}
+	}
+	urls := []string{rawURL}
+	if prefix == "" {
+		return urls
+	}
+	for _, mirror := range c.config.Mirrors[prefix] {
+		urls = append(urls, mirror+strings.TrimPrefix(rawURL, prefix))
+	}
+	return urls
+}
+
+// retryable reports whether a request that got resp and err may succeed if tried again
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch {
+	case resp.StatusCode == http.StatusRequestTimeout,
+		resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode >= 500:
+		return true
+	}
+	return false
+}
+
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.config.Backoff
+	for i := 0; i < attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+func (c *Client) try(method string, rawURL string, header http.Header, body []byte) (*http.Response, error) {
+	var resp *http.Response
+	var err error
+	for attempt := 0; ; attempt++ {
+		var req *http.Request
+		req, err = http.NewRequest(method, rawURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		if req.Header.Get("User-Agent") == "" {
+			req.Header.Set("User-Agent", c.config.UserAgent)
+		}
+
+		resp, err = c.client.Do(req)
+		if !retryable(resp, err) || attempt >= c.config.Retries {
+			return resp, err
+		}
+
+		var reason string
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		delay := c.backoff(attempt)
+		logger.Log(fmt.Sprintf("WARNING: %s %s failed: %s, retrying in %v (%d/%d)",
+			method, rawURL, reason, delay, attempt+1, c.config.Retries), c.logFilePath, c.verbose)
+		time.Sleep(delay)
+	}
+}
+
+// Do sends a request to rawURL, retrying transient failures with exponential
+// backoff and falling back to the configured mirrors in order. The response of
+// the last url tried is returned if none of them succeeds.
+func (c *Client) Do(method string, rawURL string, header http.Header, body []byte) (*http.Response, error) {
+	if c == nil {
+		c = defaultClient
+	}
+	urls := c.mirrorURLs(rawURL)
+	var resp *http.Response
+	var err error
+	for i, u := range urls {
+		if i > 0 {
+			logger.Log("Trying mirror "+u, c.logFilePath, c.verbose)
+		}
+		resp, err = c.try(method, u, header, body)
+		if err == nil && resp.StatusCode < 400 {
+			return resp, nil
+		}
+		if i < len(urls)-1 && resp != nil {
+			resp.Body.Close()
+		}
+	}
+	return resp, err
+}
+
+// Get is a shorthand for a GET request
+func (c *Client) Get(rawURL string, header http.Header) (*http.Response, error) {
+	return c.Do(http.MethodGet, rawURL, header, nil)
+}
+
+// Post is a shorthand for a POST request of contentType
+func (c *Client) Post(rawURL string, contentType string, body []byte) (*http.Response, error) {
+	return c.Do(http.MethodPost, rawURL, http.Header{"Content-Type": {contentType}}, body)
+}
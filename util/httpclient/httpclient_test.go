@@ -0,0 +1,166 @@
+package httpclient
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+)
+
+// recorder is a test server answering with the statuses queued per path
+type recorder struct {
+	mu       sync.Mutex
+	statuses map[string][]int
+	requests []string
+}
+
+func (r *recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req.URL.Path)
+	status := http.StatusOK
+	if queued := r.statuses[req.URL.Path]; len(queued) != 0 {
+		status = queued[0]
+		r.statuses[req.URL.Path] = queued[1:]
+	}
+	w.WriteHeader(status)
+	io.WriteString(w, req.URL.Path)
+}
+
+func newTestClient(t *testing.T, config models.HTTP) *Client {
+	t.Helper()
+	config.Backoff = time.Millisecond
+	client, err := New(config, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestDoRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		retries  int
+		statuses []int
+		want     int
+		requests int
+	}{
+		{"success", 2, nil, http.StatusOK, 1},
+		{"retried", 2, []int{503, 429}, http.StatusOK, 3},
+		{"retries exhausted", 2, []int{503, 503, 503}, http.StatusServiceUnavailable, 3},
+		{"no retries", 0, []int{503}, http.StatusServiceUnavailable, 1},
+		{"not retryable", 2, []int{404}, http.StatusNotFound, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := &recorder{statuses: map[string][]int{"/list": test.statuses}}
+			server := httptest.NewServer(rec)
+			defer server.Close()
+
+			client := newTestClient(t, models.HTTP{Retries: test.retries})
+			resp, err := client.Get(server.URL+"/list", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, test.want)
+			}
+			if len(rec.requests) != test.requests {
+				t.Errorf("sent %d requests, want %d", len(rec.requests), test.requests)
+			}
+		})
+	}
+}
+
+func TestDoMirrors(t *testing.T) {
+	rec := &recorder{statuses: map[string][]int{
+		"/origin/list":  {500},
+		"/mirror1/list": {404},
+	}}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	client := newTestClient(t, models.HTTP{Mirrors: map[string][]string{
+		server.URL + "/origin/": {server.URL + "/mirror1/", server.URL + "/mirror2/", server.URL + "/mirror3/"},
+	}})
+	resp, err := client.Get(server.URL+"/origin/list", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "/mirror2/list" {
+		t.Errorf("got %d %s, want 200 /mirror2/list", resp.StatusCode, body)
+	}
+	if got := strings.Join(rec.requests, " "); got != "/origin/list /mirror1/list /mirror2/list" {
+		t.Errorf("requested %s, want /origin/list /mirror1/list /mirror2/list", got)
+	}
+}
+
+func TestDoMirrorsFail(t *testing.T) {
+	rec := &recorder{statuses: map[string][]int{
+		"/origin/list": {500},
+		"/mirror/list": {404},
+	}}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	client := newTestClient(t, models.HTTP{Mirrors: map[string][]string{
+		server.URL + "/origin/": {server.URL + "/mirror/"},
+	}})
+	resp, err := client.Get(server.URL+"/origin/list", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	// The response of the last url tried is returned
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestMirrorURLs(t *testing.T) {
+	client := newTestClient(t, models.HTTP{Mirrors: map[string][]string{
+		"https://example.com/":      {"https://mirror.example.net/"},
+		"https://example.com/lists": {"https://a.example.org/lists", "https://b.example.org/ipsetfw"},
+	}})
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com/lists/drop.txt",
+			"https://example.com/lists/drop.txt https://a.example.org/lists/drop.txt https://b.example.org/ipsetfw/drop.txt"},
+		{"https://example.com/other.txt", "https://example.com/other.txt https://mirror.example.net/other.txt"},
+		{"https://example.org/drop.txt", "https://example.org/drop.txt"},
+	}
+	for _, test := range tests {
+		if got := strings.Join(client.mirrorURLs(test.url), " "); got != test.want {
+			t.Errorf("mirrorURLs(%s) = %s, want %s", test.url, got, test.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	client := &Client{config: models.HTTP{Backoff: time.Second}}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{3, 8 * time.Second},
+		{6, maxBackoff},
+		{100, maxBackoff},
+	}
+	for _, test := range tests {
+		if got := client.backoff(test.attempt); got != test.want {
+			t.Errorf("backoff(%d) = %v, want %v", test.attempt, got, test.want)
+		}
+	}
+}
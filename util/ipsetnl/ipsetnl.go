@@ -0,0 +1,188 @@
+// Package ipsetnl implements the ipset netlink operations ipset-go gets
+// wrong or lacks, on top of the same netlink plumbing.
+package ipsetnl
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"syscall"
+
+	"github.com/lrh3321/ipset-go"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// Address attributes nested in IPSET_ATTR_IP. ipset-go always uses the IPv4
+// one, which the kernel rejects for IPv6 addresses.
+const (
+	attrIPAddrIPv4 = 1
+	attrIPAddrIPv6 = 2
+)
+
+// ErrHashFull is returned when adding to a hash set holding maxelem entries.
+// ipset-go's IPSET_ERR_HASH_FULL is off by one.
+var ErrHashFull = ipset.IPSetError(ipset.IPSET_ERR_TYPE_SPECIFIC)
+
+func newRequest(cmd int, family uint8) *nl.NetlinkRequest {
+	req := nl.NewNetlinkRequest(cmd|(unix.NFNL_SUBSYS_IPSET<<8), ipset.GetCommandFlags(cmd))
+	req.AddData(&nl.Nfgenmsg{
+		NfgenFamily: family,
+		Version:     nl.NFNETLINK_V0,
+		ResId:       0,
+	})
+	req.AddData(nl.NewRtAttr(ipset.IPSET_ATTR_PROTOCOL, nl.Uint8Attr(ipset.IPSET_PROTOCOL)))
+	return req
+}
+
+func execute(req *nl.NetlinkRequest) ([][]byte, error) {
+	msgs, err := req.Execute(unix.NETLINK_NETFILTER, 0)
+	if errno, ok := err.(syscall.Errno); ok && int(errno) >= ipset.IPSET_ERR_PRIVATE {
+		err = ipset.IPSetError(uintptr(errno))
+	}
+	return msgs, err
+}
+
+func ipFamily(ip net.IP) uint8 {
+	if ip.To4() != nil {
+		return ipset.FamilyIPV4
+	}
+	return ipset.FamilyIPV6
+}
+
+// ipAttr encodes ip as the nested address attribute of the given type
+func ipAttr(attrType int, ip net.IP) *nl.RtAttr {
+	addrType := attrIPAddrIPv6
+	if ip4 := ip.To4(); ip4 != nil {
+		addrType = attrIPAddrIPv4
+		ip = ip4
+	}
+	nested := nl.NewRtAttr(attrType|int(nl.NLA_F_NESTED), nil)
+	nested.AddChild(nl.NewRtAttr(addrType|int(nl.NLA_F_NET_BYTEORDER), ip))
+	return nested
+}
+
+// adtRequest builds an add, del or test request for entry
+func adtRequest(cmd int, setName string, entry *ipset.Entry) *nl.NetlinkRequest {
+	req := newRequest(cmd, ipFamily(entry.IP))
+	req.AddData(nl.NewRtAttr(ipset.IPSET_ATTR_SETNAME, nl.ZeroTerminated(setName)))
+	// Without NLM_F_EXCL, the kernel ignores adding entries that exist and
+	// removing entries that don't, like ipset -exist
+	if (cmd == ipset.IPSET_CMD_ADD && !entry.Replace) || cmd == ipset.IPSET_CMD_DEL {
+		req.Flags |= unix.NLM_F_EXCL
+	}
+
+	data := nl.NewRtAttr(ipset.IPSET_ATTR_DATA|int(nl.NLA_F_NESTED), nil)
+	if entry.Timeout != nil {
+		data.AddChild(&nl.Uint32Attribute{Type: ipset.IPSET_ATTR_TIMEOUT | nl.NLA_F_NET_BYTEORDER, Value: *entry.Timeout})
+	}
+	if entry.IP != nil {
+		data.AddChild(ipAttr(ipset.IPSET_ATTR_IP, entry.IP))
+	}
+	if entry.CIDR != 0 {
+		data.AddChild(nl.NewRtAttr(ipset.IPSET_ATTR_CIDR, nl.Uint8Attr(entry.CIDR)))
+	}
+	if entry.IP2 != nil {
+		data.AddChild(ipAttr(ipset.IPSET_ATTR_IP2, entry.IP2))
+	}
+	if entry.CIDR2 != 0 {
+		data.AddChild(nl.NewRtAttr(ipset.IPSET_ATTR_CIDR2, nl.Uint8Attr(entry.CIDR2)))
+	}
+	if entry.Port != nil {
+		protocol := uint8(ipset.ProtocolTCP)
+		if entry.Protocol != nil {
+			protocol = *entry.Protocol
+		}
+		data.AddChild(nl.NewRtAttr(ipset.IPSET_ATTR_PROTO, nl.Uint8Attr(protocol)))
+		data.AddChild(nl.NewRtAttr(ipset.IPSET_ATTR_PORT|int(nl.NLA_F_NET_BYTEORDER), htons(*entry.Port)))
+	}
+	if entry.IFace != "" {
+		data.AddChild(nl.NewRtAttr(ipset.IPSET_ATTR_IFACE, nl.ZeroTerminated(entry.IFace)))
+	}
+	if entry.Mark != nil {
+		data.AddChild(&nl.Uint32Attribute{Type: ipset.IPSET_ATTR_MARK | nl.NLA_F_NET_BYTEORDER, Value: *entry.Mark})
+	}
+	if entry.Comment != "" {
+		data.AddChild(nl.NewRtAttr(ipset.IPSET_ATTR_COMMENT, nl.ZeroTerminated(entry.Comment)))
+	}
+	data.AddChild(&nl.Uint32Attribute{Type: ipset.IPSET_ATTR_LINENO | nl.NLA_F_NET_BYTEORDER, Value: 0})
+	req.AddData(data)
+	return req
+}
+
+func htons(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+// Add adds an entry of either family to setName
+func Add(setName string, entry *ipset.Entry) error {
+	_, err := execute(adtRequest(ipset.IPSET_CMD_ADD, setName, entry))
+	return err
+}
+
+// Del removes an entry of either family from setName
+func Del(setName string, entry *ipset.Entry) error {
+	_, err := execute(adtRequest(ipset.IPSET_CMD_DEL, setName, entry))
+	return err
+}
+
+// Test reports whether entry is in setName. In sets of networks, an entry
+// without a CIDR matches any network containing its address.
+func Test(setName string, entry *ipset.Entry) (bool, error) {
+	_, err := execute(adtRequest(ipset.IPSET_CMD_TEST, setName, entry))
+	if err == ipset.ErrEntryNotExist {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// revisions are the newest revisions of the set types ipsetfw creates.
+// Older kernels are offered older revisions.
+var revisions = map[string]uint8{
+	ipset.TypeHashIP:       4,
+	ipset.TypeHashNet:      6,
+	ipset.TypeHashIPPort:   5,
+	ipset.TypeHashNetPort:  7,
+	ipset.TypeHashNetIface: 6,
+}
+
+// Create creates a hash set like ipset.Create, which can't set maxelem.
+// options.Size is the hashsize, zero sizes leave the kernel defaults. Sets
+// always support timeouts, entries added without one use options.Timeout
+// and never expire if it's 0.
+func Create(setName string, typeName string, options ipset.CreateOptions, maxElem uint32) error {
+	revision, ok := revisions[typeName]
+	if !ok {
+		return errors.New("unsupported set type " + typeName)
+	}
+	for {
+		req := newRequest(ipset.IPSET_CMD_CREATE, options.Family)
+		req.Flags |= unix.NLM_F_EXCL
+		req.AddData(nl.NewRtAttr(ipset.IPSET_ATTR_SETNAME, nl.ZeroTerminated(setName)))
+		req.AddData(nl.NewRtAttr(ipset.IPSET_ATTR_TYPENAME, nl.ZeroTerminated(typeName)))
+		req.AddData(nl.NewRtAttr(ipset.IPSET_ATTR_REVISION, nl.Uint8Attr(revision)))
+		req.AddData(nl.NewRtAttr(ipset.IPSET_ATTR_FAMILY, nl.Uint8Attr(options.Family)))
+
+		data := nl.NewRtAttr(ipset.IPSET_ATTR_DATA|int(nl.NLA_F_NESTED), nil)
+		if options.Size > 0 {
+			data.AddChild(&nl.Uint32Attribute{Type: ipset.IPSET_ATTR_HASHSIZE | nl.NLA_F_NET_BYTEORDER, Value: options.Size})
+		}
+		if maxElem > 0 {
+			data.AddChild(&nl.Uint32Attribute{Type: ipset.IPSET_ATTR_MAXELEM | nl.NLA_F_NET_BYTEORDER, Value: maxElem})
+		}
+		data.AddChild(&nl.Uint32Attribute{Type: ipset.IPSET_ATTR_TIMEOUT | nl.NLA_F_NET_BYTEORDER, Value: options.Timeout})
+		if flags := options.CadtFlags(); flags > 0 {
+			data.AddChild(&nl.Uint32Attribute{Type: ipset.IPSET_ATTR_CADT_FLAGS | nl.NLA_F_NET_BYTEORDER, Value: flags})
+		}
+		req.AddData(data)
+
+		_, err := execute(req)
+		if err == ipset.IPSetError(ipset.IPSET_ERR_FIND_TYPE) && revision > 0 {
+			revision--
+			continue
+		}
+		return err
+	}
+}
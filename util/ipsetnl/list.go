@@ -0,0 +1,185 @@
+package ipsetnl
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/lrh3321/ipset-go"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// List returns the header and entries of setName. Unlike ipset-go it
+// decodes IPv6 entries.
+func List(setName string) (*ipset.Sets, error) {
+	req := newRequest(ipset.IPSET_CMD_LIST, unix.AF_INET)
+	req.AddData(nl.NewRtAttr(ipset.IPSET_ATTR_SETNAME, nl.ZeroTerminated(setName)))
+
+	msgs, err := execute(req)
+	if err != nil {
+		return nil, err
+	}
+	var result ipset.Sets
+	for _, msg := range msgs {
+		parseSet(&result, msg)
+	}
+	return &result, nil
+}
+
+// ListAll returns every set, one message per set
+func ListAll() ([]ipset.Sets, error) {
+	msgs, err := execute(newRequest(ipset.IPSET_CMD_LIST, unix.AF_INET))
+	if err != nil {
+		return nil, err
+	}
+	result := make([]ipset.Sets, len(msgs))
+	for i, msg := range msgs {
+		parseSet(&result[i], msg)
+	}
+	return result, nil
+}
+
+// listHeader is IPSET_FLAG_LIST_HEADER, which limits a list to set headers
+const listHeader = 1 << 2
+
+// Header returns the header of setName with its sizes and number of
+// entries, without the entries themselves
+func Header(setName string) (*ipset.Sets, error) {
+	req := newRequest(ipset.IPSET_CMD_LIST, unix.AF_INET)
+	req.AddData(nl.NewRtAttr(ipset.IPSET_ATTR_SETNAME, nl.ZeroTerminated(setName)))
+	req.AddData(&nl.Uint32Attribute{Type: ipset.IPSET_ATTR_FLAGS | nl.NLA_F_NET_BYTEORDER, Value: listHeader})
+	msgs, err := execute(req)
+	if err != nil {
+		return nil, err
+	}
+	var result ipset.Sets
+	for _, msg := range msgs {
+		parseSet(&result, msg)
+	}
+	return &result, nil
+}
+
+// ListHeaders returns the header of every set, without their entries
+func ListHeaders() ([]ipset.Sets, error) {
+	req := newRequest(ipset.IPSET_CMD_LIST, unix.AF_INET)
+	req.AddData(&nl.Uint32Attribute{Type: ipset.IPSET_ATTR_FLAGS | nl.NLA_F_NET_BYTEORDER, Value: listHeader})
+	msgs, err := execute(req)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]ipset.Sets, len(msgs))
+	for i, msg := range msgs {
+		parseSet(&result[i], msg)
+	}
+	return result, nil
+}
+
+func parseSet(result *ipset.Sets, msg []byte) {
+	result.Nfgenmsg = nl.DeserializeNfgenmsg(msg)
+	for attr := range nl.ParseAttributes(msg[4:]) {
+		switch attr.Type {
+		case ipset.IPSET_ATTR_PROTOCOL:
+			result.Protocol = attr.Value[0]
+		case ipset.IPSET_ATTR_PROTOCOL_MIN:
+			result.ProtocolMinVersion = attr.Value[0]
+		case ipset.IPSET_ATTR_SETNAME:
+			result.SetName = nl.BytesToString(attr.Value)
+		case ipset.IPSET_ATTR_TYPENAME:
+			result.TypeName = nl.BytesToString(attr.Value)
+		case ipset.IPSET_ATTR_REVISION:
+			result.Revision = attr.Value[0]
+		case ipset.IPSET_ATTR_FAMILY:
+			result.Family = attr.Value[0]
+		case ipset.IPSET_ATTR_FLAGS:
+			result.Flags = attr.Value[0]
+		case ipset.IPSET_ATTR_DATA | nl.NLA_F_NESTED:
+			parseSetData(result, attr.Value)
+		case ipset.IPSET_ATTR_ADT | nl.NLA_F_NESTED:
+			for adt := range nl.ParseAttributes(attr.Value) {
+				if adt.Type == ipset.IPSET_ATTR_DATA|nl.NLA_F_NESTED {
+					result.Entries = append(result.Entries, parseEntry(adt.Value))
+				}
+			}
+		}
+	}
+}
+
+func parseSetData(result *ipset.Sets, data []byte) {
+	for attr := range nl.ParseAttributes(data) {
+		switch attr.Type {
+		case ipset.IPSET_ATTR_HASHSIZE | nl.NLA_F_NET_BYTEORDER:
+			result.HashSize = attr.Uint32()
+		case ipset.IPSET_ATTR_MAXELEM | nl.NLA_F_NET_BYTEORDER:
+			result.MaxElements = attr.Uint32()
+		case ipset.IPSET_ATTR_TIMEOUT | nl.NLA_F_NET_BYTEORDER:
+			val := attr.Uint32()
+			result.Timeout = &val
+		case ipset.IPSET_ATTR_ELEMENTS | nl.NLA_F_NET_BYTEORDER:
+			result.NumEntries = attr.Uint32()
+		case ipset.IPSET_ATTR_REFERENCES | nl.NLA_F_NET_BYTEORDER:
+			result.References = attr.Uint32()
+		case ipset.IPSET_ATTR_MEMSIZE | nl.NLA_F_NET_BYTEORDER:
+			result.SizeInMemory = attr.Uint32()
+		case ipset.IPSET_ATTR_CADT_FLAGS | nl.NLA_F_NET_BYTEORDER:
+			result.CadtFlags = attr.Uint32()
+		case ipset.IPSET_ATTR_SIZE | nl.NLA_F_NET_BYTEORDER:
+			result.Size = attr.Uint32()
+		case ipset.IPSET_ATTR_COMMENT:
+			result.Comment = nl.BytesToString(attr.Value)
+		}
+	}
+}
+
+// nestedIP returns the address inside an IPSET_ATTR_IP style attribute,
+// whichever family it is encoded as
+func nestedIP(data []byte) net.IP {
+	for attr := range nl.ParseAttributes(data) {
+		switch attr.Type &^ nl.NLA_F_NET_BYTEORDER {
+		case attrIPAddrIPv4, attrIPAddrIPv6:
+			return net.IP(attr.Value)
+		}
+	}
+	return nil
+}
+
+func parseEntry(data []byte) (entry ipset.Entry) {
+	for attr := range nl.ParseAttributes(data) {
+		switch attr.Type {
+		case ipset.IPSET_ATTR_TIMEOUT | nl.NLA_F_NET_BYTEORDER:
+			val := attr.Uint32()
+			entry.Timeout = &val
+		case ipset.IPSET_ATTR_BYTES | nl.NLA_F_NET_BYTEORDER:
+			val := attr.Uint64()
+			entry.Bytes = &val
+		case ipset.IPSET_ATTR_PACKETS | nl.NLA_F_NET_BYTEORDER:
+			val := attr.Uint64()
+			entry.Packets = &val
+		case ipset.IPSET_ATTR_ETHER:
+			entry.MAC = net.HardwareAddr(attr.Value)
+		case ipset.IPSET_ATTR_IP | nl.NLA_F_NESTED:
+			entry.IP = nestedIP(attr.Value)
+		case ipset.IPSET_ATTR_IP2 | nl.NLA_F_NESTED:
+			entry.IP2 = nestedIP(attr.Value)
+		case ipset.IPSET_ATTR_CIDR:
+			entry.CIDR = attr.Value[0]
+		case ipset.IPSET_ATTR_CIDR2:
+			entry.CIDR2 = attr.Value[0]
+		case ipset.IPSET_ATTR_PORT | nl.NLA_F_NET_BYTEORDER:
+			val := binary.BigEndian.Uint16(attr.Value)
+			entry.Port = &val
+		case ipset.IPSET_ATTR_PROTO:
+			val := attr.Value[0]
+			entry.Protocol = &val
+		case ipset.IPSET_ATTR_IFACE:
+			entry.IFace = nl.BytesToString(attr.Value)
+		case ipset.IPSET_ATTR_NAME:
+			entry.Name = nl.BytesToString(attr.Value)
+		case ipset.IPSET_ATTR_MARK | nl.NLA_F_NET_BYTEORDER:
+			val := attr.Uint32()
+			entry.Mark = &val
+		case ipset.IPSET_ATTR_COMMENT:
+			entry.Comment = nl.BytesToString(attr.Value)
+		}
+	}
+	return
+}
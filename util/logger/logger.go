@@ -3,11 +3,17 @@ package logger
 import (
 	"fmt"
 	"os"
+	"sync"
 
 	"github.com/sabershahhoseini/ipset-firewall/error/checkerr"
 )
 
+// mu keeps lines logged by concurrent rules from interleaving
+var mu sync.Mutex
+
 func Log(log string, logFilePath string, verbose bool) {
+	mu.Lock()
+	defer mu.Unlock()
 	if verbose {
 		fmt.Println(log)
 	}
@@ -0,0 +1,204 @@
+package netutils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+	"github.com/sabershahhoseini/ipset-firewall/util/logger"
+)
+
+// DefaultCacheMaxAge is used when the cache has no maxAge configured
+const DefaultCacheMaxAge = 7 * 24 * time.Hour
+
+// cacheEntry is stored next to every cached body
+type cacheEntry struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag"`
+	LastModified string `json:"lastModified"`
+	// Checked is the last time the origin confirmed the body
+	Checked time.Time `json:"checked"`
+}
+
+func cacheKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func cachePaths(dir string, url string) (string, string) {
+	key := cacheKey(url)
+	return filepath.Join(dir, key+".body"), filepath.Join(dir, key+".json")
+}
+
+func readCache(dir string, url string) (cacheEntry, []byte, bool) {
+	var entry cacheEntry
+	bodyPath, metaPath := cachePaths(dir, url)
+	meta, err := os.ReadFile(metaPath)
+	if err != nil {
+		return entry, nil, false
+	}
+	if err := json.Unmarshal(meta, &entry); err != nil {
+		return entry, nil, false
+	}
+	body, err := os.ReadFile(bodyPath)
+	if err != nil {
+		return entry, nil, false
+	}
+	return entry, body, true
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over path,
+// so an interrupted run never leaves a half-written file behind
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func writeCache(dir string, entry cacheEntry, body []byte) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	bodyPath, metaPath := cachePaths(dir, entry.URL)
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		if err := writeFileAtomic(bodyPath, body); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(metaPath, meta)
+}
+
+// cachedGet fetches url with a conditional request against the cached copy.
+// If the fetch fails, a cached copy younger than the cache's maxAge is used.
+func cachedGet(url string, options SourceOptions) ([]byte, error) {
+	dir := options.Cache.Dir
+	entry, cachedBody, cached := readCache(dir, url)
+
+	header := http.Header{}
+	if cached {
+		if entry.ETag != "" {
+			header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	resp, err := httpRequest(url, header, options)
+	if err == nil {
+		defer resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusNotModified && cached:
+			logger.Log("Not modified, using cached copy of "+url, options.LogFilePath, options.Verbose)
+			entry.Checked = time.Now()
+			if err := writeCache(dir, entry, nil); err != nil {
+				logger.Log("WARNING: Could not update cache: "+err.Error(), options.LogFilePath, options.Verbose)
+			}
+			return cachedBody, nil
+		case resp.StatusCode == http.StatusOK:
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return nil, err
+			}
+			entry = cacheEntry{
+				URL:          url,
+				ETag:         resp.Header.Get("ETag"),
+				LastModified: resp.Header.Get("Last-Modified"),
+				Checked:      time.Now(),
+			}
+			if err := writeCache(dir, entry, body); err != nil {
+				logger.Log("WARNING: Could not write cache: "+err.Error(), options.LogFilePath, options.Verbose)
+			}
+			return body, nil
+		default:
+			err = &httpStatusError{URL: url, StatusCode: resp.StatusCode}
+		}
+	}
+
+	// A missing list is an answer, not an outage
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return nil, err
+	}
+	if !cached {
+		return nil, err
+	}
+	maxAge := options.Cache.MaxAge
+	if maxAge == 0 {
+		maxAge = DefaultCacheMaxAge
+	}
+	age := time.Since(entry.Checked)
+	if age > maxAge {
+		return nil, fmt.Errorf("%w (cached copy is %s old, older than maxAge %s)", err, age.Round(time.Second), maxAge)
+	}
+	// Stale firewall data is always reported, not only with -v
+	warning := "WARNING: Could not fetch " + url + ": " + err.Error() + ". Using cached copy from " +
+		entry.Checked.Format("2006-01-02 15:04:05")
+	logger.Log(warning, options.LogFilePath, true)
+	if options.Notify != nil {
+		options.Notify(warning)
+	}
+	return cachedBody, nil
+}
+
+// ListDigest returns a digest of a list and everything else that affects how
+// its set is built, e.g. the rule's iptables definition
+func ListDigest(ipList []string, extra ...string) string {
+	sorted := append([]string(nil), ipList...)
+	sort.Strings(sorted)
+	hash := sha256.New()
+	hash.Write([]byte(strings.Join(sorted, "\n")))
+	hash.Write([]byte(strings.Join(extra, "\n")))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func appliedPath(cache models.Cache, setName string) string {
+	return filepath.Join(cache.Dir, "applied", cacheKey(setName))
+}
+
+// IsListApplied reports whether digest is what was last applied to setName.
+// It's always false without a cache directory.
+func IsListApplied(cache models.Cache, setName string, digest string) bool {
+	if cache.Dir == "" {
+		return false
+	}
+	applied, err := os.ReadFile(appliedPath(cache, setName))
+	return err == nil && string(applied) == digest
+}
+
+// MarkListApplied records digest as the list last applied to setName
+func MarkListApplied(cache models.Cache, setName string, digest string) error {
+	if cache.Dir == "" {
+		return nil
+	}
+	path := appliedPath(cache, setName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, []byte(digest))
+}
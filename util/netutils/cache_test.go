@@ -0,0 +1,78 @@
+package netutils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+)
+
+func TestCachedGet(t *testing.T) {
+	status := http.StatusOK
+	var conditional bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conditional = r.Header.Get("If-None-Match") == `"v1"`
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		if conditional {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("192.0.2.0/24\n"))
+	}))
+	defer server.Close()
+
+	var notified []string
+	options := SourceOptions{
+		Cache:  models.Cache{Dir: t.TempDir()},
+		Notify: func(message string) { notified = append(notified, message) },
+	}
+	url := server.URL + "/list.txt"
+	for _, test := range []struct {
+		status      int
+		conditional bool
+		notified    int
+	}{
+		{http.StatusOK, false, 0},
+		{http.StatusOK, true, 0},
+		{http.StatusServiceUnavailable, true, 1},
+	} {
+		status = test.status
+		body, err := cachedGet(url, options)
+		if err != nil {
+			t.Fatalf("status %d: %v", test.status, err)
+		}
+		if string(body) != "192.0.2.0/24\n" {
+			t.Errorf("status %d: body = %q", test.status, body)
+		}
+		if conditional != test.conditional {
+			t.Errorf("status %d: conditional request = %v, want %v", test.status, conditional, test.conditional)
+		}
+		if len(notified) != test.notified {
+			t.Errorf("status %d: %d notifications, want %d", test.status, len(notified), test.notified)
+		}
+	}
+	if !strings.Contains(notified[0], "Using cached copy") {
+		t.Errorf("notification = %q", notified[0])
+	}
+
+	// A missing list isn't replaced by the cached copy
+	status = http.StatusNotFound
+	if _, err := cachedGet(url, options); err == nil {
+		t.Error("404 used the cached copy")
+	}
+
+	// Neither is a copy older than maxAge
+	status = http.StatusServiceUnavailable
+	options.Cache.MaxAge = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if _, err := cachedGet(url, options); err == nil || !strings.Contains(err.Error(), "older than maxAge") {
+		t.Errorf("stale cached copy: error = %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package netutils
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Compose operators
+const (
+	ComposeUnion     = "union"
+	ComposeIntersect = "intersect"
+	ComposeSubtract  = "subtract"
+)
+
+// ComposeExpr is a parsed compose expression: rule names combined with
+// union, intersect and subtract, evaluated left to right unless grouped with
+// parentheses, e.g. "eu subtract (de union fr)".
+type ComposeExpr struct {
+	// Name is the rule referenced by a leaf
+	Name  string
+	Op    string
+	Left  *ComposeExpr
+	Right *ComposeExpr
+}
+
+func tokenizeCompose(expr string) []string {
+	expr = strings.NewReplacer("(", " ( ", ")", " ) ").Replace(expr)
+	return strings.Fields(expr)
+}
+
+// ParseCompose parses a compose expression
+func ParseCompose(expr string) (*ComposeExpr, error) {
+	tokens := tokenizeCompose(expr)
+	if len(tokens) == 0 {
+		return nil, errors.New("empty compose expression")
+	}
+	parsed, rest, err := parseComposeExpr(tokens)
+	if err != nil {
+		return nil, fmt.Errorf("compose %q: %w", expr, err)
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("compose %q: unexpected %q", expr, rest[0])
+	}
+	return parsed, nil
+}
+
+func parseComposeExpr(tokens []string) (*ComposeExpr, []string, error) {
+	left, tokens, err := parseComposeTerm(tokens)
+	if err != nil {
+		return nil, nil, err
+	}
+	for len(tokens) > 0 && tokens[0] != ")" {
+		op := strings.ToLower(tokens[0])
+		switch op {
+		case ComposeUnion, ComposeIntersect, ComposeSubtract:
+		default:
+			return nil, nil, fmt.Errorf("expected union, intersect or subtract, got %q", tokens[0])
+		}
+		var right *ComposeExpr
+		right, tokens, err = parseComposeTerm(tokens[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		left = &ComposeExpr{Op: op, Left: left, Right: right}
+	}
+	return left, tokens, nil
+}
+
+func parseComposeTerm(tokens []string) (*ComposeExpr, []string, error) {
+	if len(tokens) == 0 {
+		return nil, nil, errors.New("unexpected end of expression")
+	}
+	switch strings.ToLower(tokens[0]) {
+	case "(":
+		inner, rest, err := parseComposeExpr(tokens[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(rest) == 0 || rest[0] != ")" {
+			return nil, nil, errors.New("missing )")
+		}
+		return inner, rest[1:], nil
+	case ")", ComposeUnion, ComposeIntersect, ComposeSubtract:
+		return nil, nil, fmt.Errorf("expected a rule name, got %q", tokens[0])
+	}
+	return &ComposeExpr{Name: tokens[0]}, tokens[1:], nil
+}
+
+// Names returns the rule names referenced by the expression
+func (e *ComposeExpr) Names() []string {
+	if e.Op == "" {
+		return []string{e.Name}
+	}
+	return append(e.Left.Names(), e.Right.Names()...)
+}
+
+// Eval evaluates the expression, looking up the prefixes of every referenced rule
+func (e *ComposeExpr) Eval(lookup func(name string) ([]netip.Prefix, error)) ([]netip.Prefix, error) {
+	if e.Op == "" {
+		return lookup(e.Name)
+	}
+	left, err := e.Left.Eval(lookup)
+	if err != nil {
+		return nil, err
+	}
+	right, err := e.Right.Eval(lookup)
+	if err != nil {
+		return nil, err
+	}
+	switch e.Op {
+	case ComposeUnion:
+		return AggregatePrefixes(append(append([]netip.Prefix{}, left...), right...)), nil
+	case ComposeIntersect:
+		return IntersectPrefixes(left, right), nil
+	default:
+		return SubtractPrefixes(left, right), nil
+	}
+}
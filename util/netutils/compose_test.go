@@ -0,0 +1,118 @@
+package netutils
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// composeString prints an expression fully parenthesized
+func composeString(e *ComposeExpr) string {
+	if e.Op == "" {
+		return e.Name
+	}
+	return "(" + composeString(e.Left) + " " + e.Op + " " + composeString(e.Right) + ")"
+}
+
+func TestParseCompose(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+		err  string
+	}{
+		{expr: "eu", want: "eu"},
+		{expr: "de union fr", want: "(de union fr)"},
+		// Operators are evaluated left to right, none binds tighter
+		{expr: "a union b subtract c", want: "((a union b) subtract c)"},
+		{expr: "a subtract b union c", want: "((a subtract b) union c)"},
+		{expr: "a union b intersect c", want: "((a union b) intersect c)"},
+		{expr: "eu subtract (de union fr)", want: "(eu subtract (de union fr))"},
+		{expr: "(a union b) intersect (c subtract d)", want: "((a union b) intersect (c subtract d))"},
+		{expr: "((a))", want: "a"},
+		{expr: "a UNION b", want: "(a union b)"},
+		{expr: "eu subtract(de)", want: "(eu subtract de)"},
+		{expr: "", err: "empty compose expression"},
+		{expr: "   ", err: "empty compose expression"},
+		{expr: "a union", err: "unexpected end of expression"},
+		{expr: "a b", err: `expected union, intersect or subtract, got "b"`},
+		{expr: "union a", err: `expected a rule name, got "union"`},
+		{expr: "a union subtract b", err: `expected a rule name, got "subtract"`},
+		{expr: "(a union b", err: "missing )"},
+		{expr: "a union b)", err: `unexpected ")"`},
+		{expr: "()", err: `expected a rule name, got ")"`},
+	}
+	for _, test := range tests {
+		expr, err := ParseCompose(test.expr)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("ParseCompose(%q) error = %v, want %q", test.expr, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseCompose(%q): %v", test.expr, err)
+			continue
+		}
+		if got := composeString(expr); got != test.want {
+			t.Errorf("ParseCompose(%q) = %s, want %s", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestComposeNames(t *testing.T) {
+	expr, err := ParseCompose("eu subtract (de union fr) intersect eu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(expr.Names(), " "); got != "eu de fr eu" {
+		t.Errorf("Names() = %s, want eu de fr eu", got)
+	}
+}
+
+func TestComposeEval(t *testing.T) {
+	lists := map[string]string{
+		"a": "10.0.0.0/24",
+		"b": "10.0.1.0/24",
+		"c": "10.0.0.128/25 10.0.1.0/25",
+	}
+	lookup := func(name string) ([]netip.Prefix, error) {
+		list, ok := lists[name]
+		if !ok {
+			return nil, errors.New("unknown rule " + name)
+		}
+		return prefixList(t, list), nil
+	}
+	tests := []struct {
+		expr string
+		want string
+		err  string
+	}{
+		{expr: "a union b", want: "10.0.0.0/23"},
+		{expr: "a intersect c", want: "10.0.0.128/25"},
+		{expr: "a union b subtract c", want: "10.0.0.0/25 10.0.1.128/25"},
+		{expr: "a union (b subtract c)", want: "10.0.0.0/24 10.0.1.128/25"},
+		{expr: "a subtract a", want: ""},
+		{expr: "a union d", err: "unknown rule d"},
+	}
+	for _, test := range tests {
+		expr, err := ParseCompose(test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prefixes, err := expr.Eval(lookup)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("Eval(%q) error = %v, want %q", test.expr, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Eval(%q): %v", test.expr, err)
+			continue
+		}
+		if got := formatPrefixes(prefixes); got != test.want {
+			t.Errorf("Eval(%q) = %s, want %s", test.expr, got, test.want)
+		}
+	}
+}
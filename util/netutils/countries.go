@@ -0,0 +1,103 @@
+package netutils
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+)
+
+// CountryGroups are the built-in country groups, user-defined groups in the
+// config take precedence over them
+var CountryGroups = map[string][]string{
+	"eu": {
+		"at", "be", "bg", "cy", "cz", "de", "dk", "ee", "es", "fi", "fr", "gr", "hr", "hu",
+		"ie", "it", "lt", "lu", "lv", "mt", "nl", "pl", "pt", "ro", "se", "si", "sk",
+	},
+	"asia": {
+		"ae", "af", "am", "az", "bd", "bh", "bn", "bt", "cn", "cy", "ge", "hk", "id", "il",
+		"in", "iq", "ir", "jo", "jp", "kg", "kh", "kp", "kr", "kw", "kz", "la", "lb", "lk",
+		"mm", "mn", "mo", "mv", "my", "np", "om", "ph", "pk", "ps", "qa", "sa", "sg", "sy",
+		"th", "tj", "tl", "tm", "tr", "tw", "uz", "vn", "ye",
+	},
+}
+
+// ExpandCountries expands group names in a list of country codes, recursively
+// since groups may contain groups. Codes are lower-cased and deduplicated.
+func ExpandCountries(entries []string, groups map[string][]string) ([]string, error) {
+	lookup := map[string][]string{}
+	for name, members := range CountryGroups {
+		lookup[name] = members
+	}
+	for name, members := range groups {
+		lookup[strings.ToLower(name)] = members
+	}
+
+	var countries []string
+	seen := map[string]bool{}
+	var expand func(entries []string, path []string) error
+	expand = func(entries []string, path []string) error {
+		for _, entry := range entries {
+			name := strings.ToLower(strings.TrimSpace(entry))
+			if name == "" {
+				continue
+			}
+			members, isGroup := lookup[name]
+			if !isGroup {
+				if !seen[name] {
+					seen[name] = true
+					countries = append(countries, name)
+				}
+				continue
+			}
+			for _, parent := range path {
+				if parent == name {
+					return errors.New("country group cycle: " + strings.Join(append(path, name), " -> "))
+				}
+			}
+			if err := expand(members, append(path, name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := expand(entries, nil); err != nil {
+		return nil, err
+	}
+	return countries, nil
+}
+
+// ExpandSourceCountries replaces the country and countries of a source with
+// the expanded list of country codes
+func ExpandSourceCountries(config *models.Source, groups map[string][]string) error {
+	if config.Country == "" && len(config.Countries) == 0 {
+		return nil
+	}
+	countries, err := ExpandCountries(append([]string{config.Country}, config.Countries...), groups)
+	if err != nil {
+		return err
+	}
+	config.Country = ""
+	config.Countries = countries
+	return nil
+}
+
+// sourceCountries returns the country codes of a source, upper-cased
+func sourceCountries(config models.Source) []string {
+	var countries []string
+	for _, country := range append([]string{config.Country}, config.Countries...) {
+		if country = strings.TrimSpace(country); country != "" {
+			countries = append(countries, strings.ToUpper(country))
+		}
+	}
+	return countries
+}
+
+// countrySet returns countries as a lookup set
+func countrySet(countries []string) map[string]bool {
+	set := map[string]bool{}
+	for _, country := range countries {
+		set[strings.ToUpper(country)] = true
+	}
+	return set
+}
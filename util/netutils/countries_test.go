@@ -0,0 +1,77 @@
+package netutils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+)
+
+func TestExpandCountries(t *testing.T) {
+	groups := map[string][]string{
+		"Neighbours": {"af", "IQ", "tr"},
+		"watched":    {"neighbours", "ru", "cn"},
+		"eu":         {"de", "fr"},
+		"loop-a":     {"ir", "loop-b"},
+		"loop-b":     {"loop-a"},
+		"self":       {"self"},
+	}
+	tests := []struct {
+		entries string
+		want    string
+		err     string
+	}{
+		{entries: "ir", want: "ir"},
+		{entries: "IR  de", want: "ir de"},
+		{entries: "ir ir de IR", want: "ir de"},
+		{entries: "neighbours", want: "af iq tr"},
+		{entries: "watched ir", want: "af iq tr ru cn ir"},
+		{entries: "tr neighbours watched", want: "tr af iq ru cn"},
+		// User-defined groups take precedence over the built-in ones
+		{entries: "eu", want: "de fr"},
+		{entries: "loop-a", err: "country group cycle: loop-a -> loop-b -> loop-a"},
+		{entries: "self", err: "country group cycle: self -> self"},
+		{entries: "", want: ""},
+	}
+	for _, test := range tests {
+		got, err := ExpandCountries(strings.Fields(test.entries), groups)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("ExpandCountries(%s) error = %v, want %q", test.entries, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ExpandCountries(%s): %v", test.entries, err)
+			continue
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("ExpandCountries(%s) = %s, want %s", test.entries, strings.Join(got, " "), test.want)
+		}
+	}
+}
+
+func TestExpandCountriesBuiltin(t *testing.T) {
+	got, err := ExpandCountries([]string{"EU"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, " ") != strings.Join(CountryGroups["eu"], " ") {
+		t.Errorf("ExpandCountries(EU) = %v, want %v", got, CountryGroups["eu"])
+	}
+}
+
+func TestExpandSourceCountries(t *testing.T) {
+	config := models.Source{Type: "country", Country: "IR", Countries: []string{"neighbours", "tr"}}
+	if err := ExpandSourceCountries(&config, map[string][]string{"neighbours": {"af", "tr"}}); err != nil {
+		t.Fatal(err)
+	}
+	if config.Country != "" || strings.Join(config.Countries, " ") != "ir af tr" {
+		t.Errorf("ExpandSourceCountries = %q %v, want \"\" [ir af tr]", config.Country, config.Countries)
+	}
+
+	config = models.Source{Type: "url", URL: []string{"https://example.com/list"}}
+	if err := ExpandSourceCountries(&config, nil); err != nil || len(config.Countries) != 0 {
+		t.Errorf("ExpandSourceCountries without countries = %v %v, want no countries", config.Countries, err)
+	}
+}
@@ -0,0 +1,30 @@
+package netutils
+
+import (
+	"errors"
+	"net/netip"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+)
+
+// ExceptPrefixes collects the networks of a rule's exceptIPs and exceptFiles.
+// The files may contain comments like commented lists.
+func ExceptPrefixes(ips []string, files []string, options SourceOptions) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, ip := range ips {
+		prefix, ok := ParsePrefix(ip)
+		if !ok {
+			return nil, errors.New("invalid exceptIPs entry " + ip)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	if len(files) == 0 {
+		return prefixes, nil
+	}
+	config := models.Source{Type: "file", Path: files, Format: models.Format{Type: "commented"}}
+	result, err := FetchSource(config, options)
+	if err != nil {
+		return nil, err
+	}
+	return append(prefixes, result.Prefixes...), nil
+}
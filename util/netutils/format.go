@@ -0,0 +1,336 @@
+package netutils
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+)
+
+// ParseList extracts the networks of a list laid out as described by format.
+// Entries that are not valid IPs or CIDRs are skipped and counted as rejected.
+func ParseList(body []byte, format models.Format) (Result, error) {
+	switch strings.ToLower(format.Type) {
+	case "", "plain":
+		return parsePrefixes(strings.Split(string(body), "\n")), nil
+	case "commented":
+		return parseCommented(body, format), nil
+	case "csv":
+		return parseCSV(body, format)
+	case "json":
+		return parseJSON(body, format)
+	case "spamhaus":
+		return parseSpamhaus(body), nil
+	case "netset":
+		return parseNetset(body), nil
+	}
+	return Result{}, fmt.Errorf("unknown list format %q", format.Type)
+}
+
+func parseCommented(body []byte, format models.Format) Result {
+	markers := []string{"#", ";"}
+	if format.Comment != "" {
+		markers = []string{format.Comment}
+	}
+	var lines []string
+	for _, line := range strings.Split(string(body), "\n") {
+		for _, marker := range markers {
+			if i := strings.Index(line, marker); i >= 0 {
+				line = line[:i]
+			}
+		}
+		lines = append(lines, line)
+	}
+	return parsePrefixes(lines)
+}
+
+func parseCSV(body []byte, format models.Format) (Result, error) {
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	if format.Delimiter != "" {
+		reader.Comma = []rune(format.Delimiter)[0]
+	}
+
+	column := format.Column - 1
+	if format.Column == 0 {
+		column = 0
+	}
+	if format.Field != "" {
+		header, err := reader.Read()
+		if err != nil {
+			return Result{}, fmt.Errorf("reading csv header: %w", err)
+		}
+		column = -1
+		for i, name := range header {
+			if strings.EqualFold(strings.TrimSpace(name), format.Field) {
+				column = i
+			}
+		}
+		if column < 0 {
+			return Result{}, fmt.Errorf("csv header has no field %q", format.Field)
+		}
+	}
+	if column < 0 {
+		return Result{}, fmt.Errorf("invalid csv column %d", format.Column)
+	}
+
+	var result Result
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Result{}, err
+		}
+		// Lines are counted from the input, so warnings name the right line
+		// despite header rows, comments and blank lines
+		if column >= len(record) {
+			line, _ := reader.FieldPos(0)
+			result.reject(line, strings.Join(record, string(reader.Comma)))
+			continue
+		}
+		line, _ := reader.FieldPos(column)
+		if entry, ok := result.addEntry(record[column]); !ok {
+			result.reject(line, entry)
+		}
+	}
+	return result, nil
+}
+
+func parseJSON(body []byte, format models.Format) (Result, error) {
+	var document interface{}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return Result{}, err
+	}
+	path := format.Path
+	if path == "" {
+		// Without a path every string in the document is a candidate
+		path = "$..*"
+	}
+	values, err := EvalJSONPath(document, path)
+	if err != nil {
+		return Result{}, err
+	}
+	var result Result
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+		if entry, ok := result.addEntry(str); !ok {
+			result.rejectValue(i, entry)
+		}
+	}
+	return result, nil
+}
+
+// parseSpamhaus parses Spamhaus DROP/EDROP lists. The text format has
+// `CIDR ; SBLxxx` lines with `;` comments, the json format one object per line.
+func parseSpamhaus(body []byte) Result {
+	result := Result{References: map[netip.Prefix]string{}}
+	for i, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		var network, reference string
+		if strings.HasPrefix(line, "{") {
+			var record struct {
+				CIDR  string `json:"cidr"`
+				SBLID string `json:"sblid"`
+			}
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				result.reject(i+1, line)
+				continue
+			}
+			// The last line holds metadata about the list
+			if record.CIDR == "" {
+				continue
+			}
+			network, reference = record.CIDR, record.SBLID
+		} else {
+			network, reference, _ = strings.Cut(line, ";")
+		}
+
+		prefix, ok := ParsePrefix(network)
+		if !ok {
+			result.reject(i+1, line)
+			continue
+		}
+		result.Prefixes = append(result.Prefixes, prefix)
+		if reference = strings.TrimSpace(reference); reference != "" {
+			result.References[prefix] = reference
+		}
+	}
+	return result
+}
+
+// parseNetset parses FireHOL .netset/.ipset files. `# key : value` header
+// lines are kept as metadata.
+func parseNetset(body []byte) Result {
+	result := Result{Metadata: map[string]string{}}
+	var lines []string
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			key, value, found := strings.Cut(strings.TrimLeft(line, "# "), ":")
+			if found && strings.TrimSpace(key) != "" && strings.TrimSpace(value) != "" {
+				result.Metadata[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+			}
+			// Keep the line so warnings name the right line number
+			line = ""
+		}
+		lines = append(lines, line)
+	}
+	parsed := parsePrefixes(lines)
+	result.Prefixes = parsed.Prefixes
+	result.Rejected = parsed.Rejected
+	result.Warnings = parsed.Warnings
+	return result
+}
+
+type jsonPathStep struct {
+	key       string
+	index     int
+	wildcard  bool
+	recursive bool
+	isIndex   bool
+}
+
+// EvalJSONPath evaluates a JSONPath subset on a decoded json document.
+// Supported are `$`, `.key`, `['key']`, `[n]`, `[*]`, `.*` and `..key`.
+func EvalJSONPath(document interface{}, path string) ([]interface{}, error) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []interface{}{document}
+	for _, step := range steps {
+		var next []interface{}
+		for _, node := range nodes {
+			if step.recursive {
+				for _, descendant := range jsonDescendants(node) {
+					next = append(next, jsonChildren(descendant, step)...)
+				}
+				continue
+			}
+			next = append(next, jsonChildren(node, step)...)
+		}
+		nodes = next
+	}
+	return nodes, nil
+}
+
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("json path %q must start with $", path)
+	}
+	path = path[1:]
+	var steps []jsonPathStep
+	for len(path) > 0 {
+		var step jsonPathStep
+		switch {
+		case strings.HasPrefix(path, ".."):
+			step.recursive = true
+			path = path[2:]
+		case path[0] == '.':
+			path = path[1:]
+		}
+		if path == "" {
+			return nil, errors.New("json path ends with a dot")
+		}
+
+		if path[0] == '[' {
+			end := strings.IndexByte(path, ']')
+			if end < 0 {
+				return nil, errors.New("json path has an unclosed bracket")
+			}
+			inner := strings.TrimSpace(path[1:end])
+			path = path[end+1:]
+			switch {
+			case inner == "*":
+				step.wildcard = true
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"'):
+				step.key = inner[1 : len(inner)-1]
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid json path index %q", inner)
+				}
+				step.index = index
+				step.isIndex = true
+			}
+		} else {
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			step.key = path[:end]
+			path = path[end:]
+			if step.key == "*" {
+				step.wildcard = true
+			}
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+func jsonChildren(node interface{}, step jsonPathStep) []interface{} {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		if step.wildcard {
+			var children []interface{}
+			for _, child := range value {
+				children = append(children, child)
+			}
+			return children
+		}
+		if child, ok := value[step.key]; ok && !step.isIndex {
+			return []interface{}{child}
+		}
+	case []interface{}:
+		if step.wildcard {
+			return value
+		}
+		if step.isIndex {
+			index := step.index
+			if index < 0 {
+				index += len(value)
+			}
+			if index >= 0 && index < len(value) {
+				return []interface{}{value[index]}
+			}
+		}
+	}
+	return nil
+}
+
+// jsonDescendants returns node and all nodes below it
+func jsonDescendants(node interface{}) []interface{} {
+	nodes := []interface{}{node}
+	switch value := node.(type) {
+	case map[string]interface{}:
+		for _, child := range value {
+			nodes = append(nodes, jsonDescendants(child)...)
+		}
+	case []interface{}:
+		for _, child := range value {
+			nodes = append(nodes, jsonDescendants(child)...)
+		}
+	}
+	return nodes
+}
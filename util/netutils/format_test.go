@@ -0,0 +1,179 @@
+package netutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+)
+
+func TestEvalJSONPath(t *testing.T) {
+	document := `{
+		"prefixes": [
+			{"ip_prefix": "192.0.2.0/24", "region": "eu-west-1"},
+			{"ip_prefix": "198.51.100.0/24", "region": "us-east-1"}
+		],
+		"ipv6_prefixes": [{"ipv6_prefix": "2001:db8::/32"}],
+		"meta": {"key.with.dots": "x", "count": 3}
+	}`
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(document), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want string
+		err  bool
+	}{
+		{path: "$.prefixes[*].ip_prefix", want: "192.0.2.0/24 198.51.100.0/24"},
+		{path: "$.prefixes[0].ip_prefix", want: "192.0.2.0/24"},
+		{path: "$.prefixes[-1].region", want: "us-east-1"},
+		{path: "$.prefixes[5].ip_prefix", want: ""},
+		{path: "$['ipv6_prefixes'][*]['ipv6_prefix']", want: "2001:db8::/32"},
+		{path: "$..ipv6_prefix", want: "2001:db8::/32"},
+		{path: "$..ip_prefix", want: "192.0.2.0/24 198.51.100.0/24"},
+		{path: "$.meta['key.with.dots']", want: "x"},
+		{path: "$.meta.count", want: "3"},
+		{path: "$.meta.*", want: "3 x"},
+		{path: "$.missing", want: ""},
+		{path: "prefixes", err: true},
+		{path: "$.prefixes.", err: true},
+		{path: "$.prefixes[0", err: true},
+		{path: "$.prefixes[x]", err: true},
+	}
+	for _, test := range tests {
+		values, err := EvalJSONPath(decoded, test.path)
+		if test.err {
+			if err == nil {
+				t.Errorf("EvalJSONPath(%s) = %v, want an error", test.path, values)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("EvalJSONPath(%s): %v", test.path, err)
+			continue
+		}
+		var strs []string
+		for _, value := range values {
+			strs = append(strs, fmt.Sprint(value))
+		}
+		// Object members have no order
+		sort.Strings(strs)
+		if got := strings.Join(strs, " "); got != test.want {
+			t.Errorf("EvalJSONPath(%s) = %s, want %s", test.path, got, test.want)
+		}
+	}
+}
+
+func TestParseList(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		format   models.Format
+		want     string
+		warnings []string
+	}{
+		{
+			name:     "plain",
+			body:     "192.0.2.0/24\n\n# comment\nbad\n2001:db8::1 # host\n",
+			want:     "192.0.2.0/24 2001:db8::1/128",
+			warnings: []string{`line 4: invalid entry "bad"`},
+		},
+		{
+			name:     "commented",
+			body:     "; header\n192.0.2.0/24 ; SBL1\nbad ; x\n",
+			format:   models.Format{Type: "commented"},
+			want:     "192.0.2.0/24",
+			warnings: []string{`line 3: invalid entry "bad"`},
+		},
+		{
+			name:     "csv column",
+			body:     "192.0.2.0/24,a\n\n198.51.100.0/24,b\nbad,c\n",
+			format:   models.Format{Type: "csv"},
+			want:     "192.0.2.0/24 198.51.100.0/24",
+			warnings: []string{`line 4: invalid entry "bad"`},
+		},
+		{
+			name:     "csv short row",
+			body:     "a,192.0.2.0/24\nb\nc,198.51.100.0/24\n",
+			format:   models.Format{Type: "csv", Column: 2},
+			want:     "192.0.2.0/24 198.51.100.0/24",
+			warnings: []string{`line 2: invalid entry "b"`},
+		},
+		{
+			name:     "csv field",
+			body:     "# list\nname;network\n\na;192.0.2.0/24\n\"b\nc\";bad\nd;198.51.100.0/24\n",
+			format:   models.Format{Type: "csv", Delimiter: ";", Field: "network"},
+			want:     "192.0.2.0/24 198.51.100.0/24",
+			warnings: []string{`line 6: invalid entry "bad"`},
+		},
+		{
+			name:     "spamhaus text",
+			body:     "; Spamhaus DROP List\n192.0.2.0/24 ; SBL1\nbad ; SBL2\n",
+			format:   models.Format{Type: "spamhaus"},
+			want:     "192.0.2.0/24",
+			warnings: []string{`line 3: invalid entry "bad ; SBL2"`},
+		},
+		{
+			name: "spamhaus json",
+			body: `{"cidr":"192.0.2.0/24","sblid":"SBL1"}` + "\n{broken\n" +
+				`{"type":"metadata","records":1}` + "\n",
+			format:   models.Format{Type: "spamhaus"},
+			want:     "192.0.2.0/24",
+			warnings: []string{`line 2: invalid entry "{broken"`},
+		},
+		{
+			name:     "netset",
+			body:     "# Entries : 2\n#\n192.0.2.0/24\nbad\n",
+			format:   models.Format{Type: "netset"},
+			want:     "192.0.2.0/24",
+			warnings: []string{`line 4: invalid entry "bad"`},
+		},
+		{
+			name:   "json",
+			body:   `{"prefixes": [{"ip_prefix": "192.0.2.0/24"}, {"ip_prefix": "198.51.100.0/24"}]}`,
+			format: models.Format{Type: "json", Path: "$.prefixes[*].ip_prefix"},
+			want:   "192.0.2.0/24 198.51.100.0/24",
+		},
+		{
+			name:     "json rejected value",
+			body:     `{"prefixes": ["192.0.2.0/24", 7, "bad", " ", "198.51.100.0/24"]}`,
+			format:   models.Format{Type: "json", Path: "$.prefixes[*]"},
+			want:     "192.0.2.0/24 198.51.100.0/24",
+			warnings: []string{`value 2: invalid entry "bad"`},
+		},
+	}
+	for _, test := range tests {
+		result, err := ParseList([]byte(test.body), test.format)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := formatPrefixes(result.Prefixes); got != test.want {
+			t.Errorf("%s: prefixes = %s, want %s", test.name, got, test.want)
+		}
+		if got, want := strings.Join(result.Warnings, "; "), strings.Join(test.warnings, "; "); got != want {
+			t.Errorf("%s: warnings = %s, want %s", test.name, got, want)
+		}
+		if result.Rejected != len(test.warnings) {
+			t.Errorf("%s: rejected = %d, want %d", test.name, result.Rejected, len(test.warnings))
+		}
+	}
+}
+
+func TestParseListSpamhausReferences(t *testing.T) {
+	result, err := ParseList([]byte("192.0.2.0/24 ; SBL1\n198.51.100.0/24\n"), models.Format{Type: "spamhaus"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	references := map[string]string{}
+	for prefix, reference := range result.References {
+		references[prefix.String()] = reference
+	}
+	if len(references) != 1 || references["192.0.2.0/24"] != "SBL1" {
+		t.Errorf("references = %v, want 192.0.2.0/24: SBL1", references)
+	}
+}
@@ -3,7 +3,6 @@ package netutils
 import (
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"net/http"
 	"net/netip"
@@ -11,11 +10,13 @@ import (
 
 	"github.com/EvilSuperstars/go-cidrman"
 	"github.com/sabershahhoseini/ipset-firewall/error/checkerr"
+	"github.com/sabershahhoseini/ipset-firewall/models"
 	"github.com/sabershahhoseini/ipset-firewall/util/file"
 	"github.com/sabershahhoseini/ipset-firewall/util/logger"
 )
 
 const GeoURL string = "https://raw.githubusercontent.com/herrbischoff/country-ip-blocks/master/ipv4/COUNTRY_CODE.cidr"
+const GeoURL6 string = "https://raw.githubusercontent.com/herrbischoff/country-ip-blocks/master/ipv6/COUNTRY_CODE.cidr"
 const TorURL string = "https://raw.githubusercontent.com/SecOps-Institute/Tor-IP-Addresses/master/tor-exit-nodes.lst"
 
 func IsCIDRValid(ip string) (string, bool) {
@@ -25,27 +26,36 @@ func IsCIDRValid(ip string) (string, bool) {
 		if tmpIP == nil {
 			return "", false
 		}
+		if tmpIP.To4() == nil {
+			return ip + "/128", true
+		}
 		return ip + "/32", true
 	}
 	return ip, true
 }
 
+// NetworkContainsIP reports whether cidr contains ip. It's false if either
+// of them doesn't parse.
 func NetworkContainsIP(cidr string, ip string) bool {
-	network, err := netip.ParsePrefix(cidr)
-	if err != nil {
-		panic(err)
+	network, ok := ParsePrefix(cidr)
+	if !ok {
+		return false
 	}
 
-	parsedIP, err := netip.ParseAddr(ip)
+	parsedIP, err := netip.ParseAddr(strings.TrimSpace(ip))
 	if err != nil {
-		panic(err)
+		return false
 	}
 
-	b := network.Contains(parsedIP)
+	b := network.Contains(parsedIP.Unmap())
 	return b
 }
 
 func CheckIPExistsInPool(ipList []string, targetIP string, verbose bool) bool {
+	if _, err := netip.ParseAddr(strings.TrimSpace(targetIP)); err != nil {
+		fmt.Printf("%v is not a valid IP address.\n", targetIP)
+		return false
+	}
 	for _, ip := range ipList {
 
 		_, isValid := IsCIDRValid(ip)
@@ -62,60 +72,87 @@ func CheckIPExistsInPool(ipList []string, targetIP string, verbose bool) bool {
 }
 
 func FetchIPPool(countryCode string, verbose bool, filePath string, logFilePath string) []string {
-
-	var ipList []string
-	var url string
-	countryCode = strings.ToLower(countryCode)
-
-	if countryCode == "tor" {
-		url = TorURL
-	} else {
-		tmpUrl := GeoURL
-		url = strings.Replace(tmpUrl, "COUNTRY_CODE", countryCode, 1)
+	rule := file.Rule{Country: countryCode}
+	// -country accepts a comma separated list of countries and groups
+	if strings.Contains(countryCode, ",") {
+		rule = file.Rule{Countries: strings.Split(countryCode, ",")}
 	}
-
-	// If file argument is passed, read file and create set
 	if filePath != "" {
-		logger.Log("Reading file from "+filePath, logFilePath, verbose)
-		ipList = file.ReadListFile(filePath)
-		list := MergeIPsToCIDRs(ipList)
-		fmt.Println(list)
-		return list
-	}
-	// Else, go fetch from github
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		log.Fatalln(err)
+		rule.Path = []string{filePath}
 	}
+	source := LegacySource(rule)
+	err := ExpandSourceCountries(&source, nil)
+	checkerr.Fatal(err)
+	result, err := FetchSource(source, SourceOptions{Verbose: verbose, LogFilePath: logFilePath})
+	checkerr.Fatal(err)
+	return result.IPList()
+}
 
-	client := &http.Client{}
+type httpStatusError struct {
+	URL        string
+	StatusCode int
+}
 
-	logger.Log("Trying to get url: "+url, logFilePath, verbose)
-	resp, err := client.Do(req)
+func (e *httpStatusError) Error() string {
+	return "GET " + e.URL + ": unexpected status " + fmt.Sprint(e.StatusCode)
+}
+
+func httpRequest(url string, header http.Header, options SourceOptions) (*http.Response, error) {
+	logger.Log("Trying to get url: "+url, options.LogFilePath, options.Verbose)
+	return options.HTTP.Get(url, header)
+}
+
+func httpGet(url string, options SourceOptions) ([]byte, error) {
+	if options.Cache.Dir != "" {
+		return cachedGet(url, options)
+	}
+	resp, err := httpRequest(url, nil, options)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
 	}
 
 	defer resp.Body.Close()
 
-	b, err := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, &httpStatusError{URL: url, StatusCode: resp.StatusCode}
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func fetchLines(url string, verify models.Verify, options SourceOptions) ([]string, error) {
+	b, err := fetchVerified(url, verify, options)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
 	}
-	list := strings.Split(string(b), "\n")
-	logger.Log("Finished fetching list of IPs", logFilePath, verbose)
-	list = MergeIPsToCIDRs(list)
-	return list
+	logger.Log("Finished fetching list of IPs", options.LogFilePath, options.Verbose)
+	return strings.Split(string(b), "\n"), nil
 }
 
+// MergeIPsToCIDRs aggregates a list of IPs and CIDRs of both families.
+// IPv4 entries are merged with cidrman, IPv6 entries with AggregatePrefixes.
 func MergeIPsToCIDRs(ipList []string) []string {
 
 	var ipNet *net.IPNet
 	var ipNetList []*net.IPNet
+	var ipv6List []netip.Prefix
 	var ipListMerged []string
 
 	for _, ip := range ipList {
-		if ip == "" || strings.Contains(ip, ":") {
+		ip = strings.TrimSpace(ip)
+		if ip == "" {
+			continue
+		}
+		if strings.Contains(ip, ":") {
+			prefix, ok := ParsePrefix(ip)
+			if !ok {
+				continue
+			}
+			if prefix.Addr().Is4() {
+				_, ipNet, _ = net.ParseCIDR(prefix.String())
+				ipNetList = append(ipNetList, ipNet)
+				continue
+			}
+			ipv6List = append(ipv6List, prefix)
 			continue
 		}
 		if !strings.Contains(ip, "/") {
@@ -131,5 +168,8 @@ func MergeIPsToCIDRs(ipList []string) []string {
 	for _, ip := range merged {
 		ipListMerged = append(ipListMerged, ip.String())
 	}
+	for _, prefix := range AggregatePrefixes(ipv6List) {
+		ipListMerged = append(ipListMerged, prefix.String())
+	}
 	return ipListMerged
 }
@@ -0,0 +1,284 @@
+package netutils
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// ParsePrefix parses an IP or CIDR of either family. Bare addresses become
+// host prefixes (/32 or /128) and IPv4-mapped IPv6 addresses are unmapped.
+func ParsePrefix(ip string) (netip.Prefix, bool) {
+	ip = strings.TrimSpace(ip)
+	if !strings.Contains(ip, "/") {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			return netip.Prefix{}, false
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), true
+	}
+	prefix, err := netip.ParsePrefix(ip)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	if prefix.Addr().Is4In6() {
+		bits := prefix.Bits() - 96
+		if bits < 0 {
+			return netip.Prefix{}, false
+		}
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), bits)
+	}
+	return prefix.Masked(), true
+}
+
+// ParseListEntry parses an entry of a list: an IP, a CIDR, an IPv4 address
+// with a netmask (a.b.c.d/255.255.0.0) or an inclusive range (a.b.c.d-e.f.g.h),
+// which is converted to the minimal list of CIDRs covering it.
+func ParseListEntry(entry string) ([]netip.Prefix, bool) {
+	entry = strings.TrimSpace(entry)
+	if start, end, found := strings.Cut(entry, "-"); found {
+		startAddr, err := netip.ParseAddr(strings.TrimSpace(start))
+		if err != nil {
+			return nil, false
+		}
+		endAddr, err := netip.ParseAddr(strings.TrimSpace(end))
+		if err != nil {
+			return nil, false
+		}
+		prefixes := RangeToPrefixes(startAddr, endAddr)
+		return prefixes, len(prefixes) > 0
+	}
+	if ip, mask, found := strings.Cut(entry, "/"); found && strings.Contains(mask, ".") {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil || !addr.Unmap().Is4() {
+			return nil, false
+		}
+		maskLen, ok := netmaskBits(mask)
+		if !ok {
+			return nil, false
+		}
+		prefix, err := addr.Unmap().Prefix(maskLen)
+		return []netip.Prefix{prefix}, err == nil
+	}
+	prefix, ok := ParsePrefix(entry)
+	if !ok {
+		return nil, false
+	}
+	return []netip.Prefix{prefix}, true
+}
+
+// netmaskBits returns the prefix length of a dotted IPv4 netmask
+func netmaskBits(mask string) (int, bool) {
+	addr, err := netip.ParseAddr(mask)
+	if err != nil || !addr.Is4() {
+		return 0, false
+	}
+	b := addr.As4()
+	value := binary.BigEndian.Uint32(b[:])
+	ones := bits.OnesCount32(value)
+	if value != ^uint32(0)<<(32-ones) {
+		return 0, false
+	}
+	return ones, true
+}
+
+func comparePrefix(a, b netip.Prefix) int {
+	if c := a.Addr().Compare(b.Addr()); c != 0 {
+		return c
+	}
+	return a.Bits() - b.Bits()
+}
+
+// AggregatePrefixes returns the smallest list of prefixes covering exactly the
+// same addresses as the input. It works for both IPv4 and IPv6; duplicates and
+// prefixes contained in others are dropped and adjacent siblings are merged.
+func AggregatePrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	sorted := make([]netip.Prefix, 0, len(prefixes))
+	for _, p := range prefixes {
+		if p.IsValid() {
+			sorted = append(sorted, p.Masked())
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return comparePrefix(sorted[i], sorted[j]) < 0
+	})
+
+	var aggregated []netip.Prefix
+	for _, p := range sorted {
+		if n := len(aggregated); n > 0 {
+			last := aggregated[n-1]
+			if last.Bits() <= p.Bits() && last.Contains(p.Addr()) {
+				continue
+			}
+		}
+		aggregated = append(aggregated, p)
+
+		// Collapse the tail as long as the last two prefixes are siblings
+		for len(aggregated) >= 2 {
+			a := aggregated[len(aggregated)-2]
+			b := aggregated[len(aggregated)-1]
+			if a.Bits() != b.Bits() || a.Bits() == 0 || a.Addr().BitLen() != b.Addr().BitLen() {
+				break
+			}
+			parentA, _ := a.Addr().Prefix(a.Bits() - 1)
+			parentB, _ := b.Addr().Prefix(b.Bits() - 1)
+			if parentA != parentB {
+				break
+			}
+			aggregated = append(aggregated[:len(aggregated)-2], parentA)
+		}
+	}
+	return aggregated
+}
+
+// SplitByFamily separates a list of IPs/CIDRs into IPv4 and IPv6 entries.
+// Entries that can't be parsed are dropped.
+func SplitByFamily(ipList []string) ([]string, []string) {
+	var ipv4List []string
+	var ipv6List []string
+	for _, ip := range ipList {
+		prefix, ok := ParsePrefix(ip)
+		if !ok {
+			continue
+		}
+		if prefix.Addr().Is4() {
+			ipv4List = append(ipv4List, prefix.String())
+		} else {
+			ipv6List = append(ipv6List, prefix.String())
+		}
+	}
+	return ipv4List, ipv6List
+}
+
+// lastAddr returns the highest address covered by prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	prefix = prefix.Masked()
+	bytes := prefix.Addr().As16()
+	offset := 128 - prefix.Addr().BitLen()
+	for bit := offset + prefix.Bits(); bit < 128; bit++ {
+		bytes[bit/8] |= 1 << (7 - bit%8)
+	}
+	addr := netip.AddrFrom16(bytes)
+	if prefix.Addr().Is4() {
+		return addr.Unmap()
+	}
+	return addr
+}
+
+// RangeToPrefixes converts an inclusive address range to the minimal list of
+// prefixes covering it. Both addresses must be of the same family.
+func RangeToPrefixes(start, end netip.Addr) []netip.Prefix {
+	var prefixes []netip.Prefix
+	start = start.Unmap()
+	end = end.Unmap()
+	if start.BitLen() != end.BitLen() || end.Less(start) {
+		return nil
+	}
+	for {
+		// Find the largest prefix aligned on start that doesn't exceed end
+		bits := start.BitLen()
+		for bits > 0 {
+			candidate := netip.PrefixFrom(start, bits-1)
+			if candidate.Masked().Addr() != start || end.Less(lastAddr(candidate)) {
+				break
+			}
+			bits--
+		}
+		prefix := netip.PrefixFrom(start, bits)
+		prefixes = append(prefixes, prefix)
+		last := lastAddr(prefix)
+		if last == end {
+			return prefixes
+		}
+		start = last.Next()
+	}
+}
+
+// overlapping returns the prefixes of list that share addresses with prefix
+func overlapping(prefix netip.Prefix, list []netip.Prefix) []netip.Prefix {
+	var result []netip.Prefix
+	for _, p := range list {
+		if p.Overlaps(prefix) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// subtractPrefix removes the addresses of excluded from prefix, splitting it
+// in halves until every part is either disjoint from or covered by excluded
+func subtractPrefix(prefix netip.Prefix, excluded []netip.Prefix) []netip.Prefix {
+	excluded = overlapping(prefix, excluded)
+	if len(excluded) == 0 {
+		return []netip.Prefix{prefix}
+	}
+	for _, p := range excluded {
+		if p.Bits() <= prefix.Bits() {
+			return nil
+		}
+	}
+	bits := prefix.Bits() + 1
+	low := netip.PrefixFrom(prefix.Addr(), bits)
+	high := netip.PrefixFrom(lastAddr(prefix), bits).Masked()
+	return append(subtractPrefix(low, excluded), subtractPrefix(high, excluded)...)
+}
+
+// SubtractPrefixes returns the addresses of prefixes that aren't in excluded,
+// as an aggregated list of prefixes
+func SubtractPrefixes(prefixes []netip.Prefix, excluded []netip.Prefix) []netip.Prefix {
+	excluded = AggregatePrefixes(excluded)
+	var result []netip.Prefix
+	for _, prefix := range AggregatePrefixes(prefixes) {
+		// excluded is sorted and disjoint, so the ones overlapping prefix are contiguous
+		first := sort.Search(len(excluded), func(i int) bool {
+			return !lastAddr(excluded[i]).Less(prefix.Addr())
+		})
+		last := lastAddr(prefix)
+		end := sort.Search(len(excluded), func(i int) bool {
+			return last.Less(excluded[i].Addr())
+		})
+		if first >= end {
+			result = append(result, prefix)
+			continue
+		}
+		result = append(result, subtractPrefix(prefix, excluded[first:end])...)
+	}
+	return AggregatePrefixes(result)
+}
+
+// IntersectPrefixes returns the addresses that are in both a and b
+func IntersectPrefixes(a []netip.Prefix, b []netip.Prefix) []netip.Prefix {
+	return SubtractPrefixes(a, SubtractPrefixes(a, b))
+}
+
+// PrefixesFromIPs parses a list of IPs and CIDRs, dropping invalid entries
+func PrefixesFromIPs(ipList []string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, ip := range ipList {
+		if prefix, ok := ParsePrefix(ip); ok {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// IPsFromPrefixes formats prefixes as CIDRs
+func IPsFromPrefixes(prefixes []netip.Prefix) []string {
+	ipList := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		ipList = append(ipList, prefix.String())
+	}
+	return ipList
+}
+
+// SubtractIPs removes the addresses of excluded from a list of IPs and CIDRs.
+// Entries that can't be parsed are dropped.
+func SubtractIPs(ipList []string, excluded []netip.Prefix) []string {
+	if len(excluded) == 0 {
+		return ipList
+	}
+	return IPsFromPrefixes(SubtractPrefixes(PrefixesFromIPs(ipList), excluded))
+}
@@ -0,0 +1,182 @@
+package netutils
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// prefixList parses a space separated list of prefixes
+func prefixList(t *testing.T, list string) []netip.Prefix {
+	t.Helper()
+	var prefixes []netip.Prefix
+	for _, s := range strings.Fields(list) {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
+
+func formatPrefixes(prefixes []netip.Prefix) string {
+	var strs []string
+	for _, prefix := range prefixes {
+		strs = append(strs, prefix.String())
+	}
+	return strings.Join(strs, " ")
+}
+
+func TestParseListEntry(t *testing.T) {
+	tests := []struct {
+		entry string
+		want  string
+		ok    bool
+	}{
+		{"192.0.2.1", "192.0.2.1/32", true},
+		{" 192.0.2.0/24 ", "192.0.2.0/24", true},
+		{"192.0.2.77/24", "192.0.2.0/24", true},
+		{"2001:db8::1", "2001:db8::1/128", true},
+		{"2001:db8::/32", "2001:db8::/32", true},
+		{"::ffff:192.0.2.1", "192.0.2.1/32", true},
+		{"::ffff:192.0.2.0/120", "192.0.2.0/24", true},
+		{"192.0.2.0/255.255.255.0", "192.0.2.0/24", true},
+		{"10.1.2.3/255.255.0.0", "10.1.0.0/16", true},
+		{"192.0.2.0-192.0.2.255", "192.0.2.0/24", true},
+		{"192.0.2.1 - 192.0.2.4", "192.0.2.1/32 192.0.2.2/31 192.0.2.4/32", true},
+		{"2001:db8::-2001:db8::ffff", "2001:db8::/112", true},
+		{"192.0.2.0/255.0.255.0", "", false},
+		{"2001:db8::/255.255.0.0", "", false},
+		{"192.0.2.9-192.0.2.1", "", false},
+		{"192.0.2.1-2001:db8::1", "", false},
+		{"192.0.2.0/33", "", false},
+		{"example.com", "", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		prefixes, ok := ParseListEntry(test.entry)
+		if ok != test.ok {
+			t.Errorf("ParseListEntry(%q) ok = %v, want %v", test.entry, ok, test.ok)
+			continue
+		}
+		if got := formatPrefixes(prefixes); ok && got != test.want {
+			t.Errorf("ParseListEntry(%q) = %s, want %s", test.entry, got, test.want)
+		}
+	}
+}
+
+func TestAggregatePrefixes(t *testing.T) {
+	tests := []struct {
+		prefixes string
+		want     string
+	}{
+		{"10.0.0.0/25 10.0.0.128/25", "10.0.0.0/24"},
+		{"10.0.0.0/24 10.0.0.5/32 10.0.0.0/24", "10.0.0.0/24"},
+		{"10.0.0.128/25 10.0.1.0/25", "10.0.0.128/25 10.0.1.0/25"},
+		{"10.0.0.0/26 10.0.0.64/26 10.0.0.128/25", "10.0.0.0/24"},
+		{"2001:db8:1::/48 2001:db8::/48", "2001:db8::/47"},
+		{"0.0.0.0/1 128.0.0.0/1", "0.0.0.0/0"},
+		{"0.0.0.0/0 ::/0", "0.0.0.0/0 ::/0"},
+	}
+	for _, test := range tests {
+		if got := formatPrefixes(AggregatePrefixes(prefixList(t, test.prefixes))); got != test.want {
+			t.Errorf("AggregatePrefixes(%s) = %s, want %s", test.prefixes, got, test.want)
+		}
+	}
+}
+
+func TestSplitByFamily(t *testing.T) {
+	ipv4, ipv6 := SplitByFamily([]string{"192.0.2.0/24", "2001:db8::/32", "bad", "::ffff:198.51.100.1", "2001:db8::1"})
+	if got := strings.Join(ipv4, " "); got != "192.0.2.0/24 198.51.100.1/32" {
+		t.Errorf("IPv4 entries = %s", got)
+	}
+	if got := strings.Join(ipv6, " "); got != "2001:db8::/32 2001:db8::1/128" {
+		t.Errorf("IPv6 entries = %s", got)
+	}
+}
+
+func TestMergeIPsToCIDRs(t *testing.T) {
+	got := MergeIPsToCIDRs([]string{"192.0.2.0/25", "192.0.2.128/25", "2001:db8::/33", "2001:db8:8000::/33", " ", "192.0.2.7"})
+	if strings.Join(got, " ") != "192.0.2.0/24 2001:db8::/32" {
+		t.Errorf("MergeIPsToCIDRs = %s, want 192.0.2.0/24 2001:db8::/32", strings.Join(got, " "))
+	}
+}
+
+func TestRangeToPrefixes(t *testing.T) {
+	tests := []struct {
+		start, end string
+		want       string
+	}{
+		{"192.0.2.0", "192.0.2.0", "192.0.2.0/32"},
+		{"192.0.2.0", "192.0.2.255", "192.0.2.0/24"},
+		{"192.0.2.1", "192.0.2.254", "192.0.2.1/32 192.0.2.2/31 192.0.2.4/30 192.0.2.8/29 192.0.2.16/28 " +
+			"192.0.2.32/27 192.0.2.64/26 192.0.2.128/26 192.0.2.192/27 192.0.2.224/28 192.0.2.240/29 " +
+			"192.0.2.248/30 192.0.2.252/31 192.0.2.254/32"},
+		{"10.0.0.0", "10.1.255.255", "10.0.0.0/15"},
+		{"0.0.0.0", "255.255.255.255", "0.0.0.0/0"},
+		{"255.255.255.254", "255.255.255.255", "255.255.255.254/31"},
+		{"::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "::/0"},
+		{"2001:db8::1", "2001:db8::2", "2001:db8::1/128 2001:db8::2/128"},
+		{"::ffff:192.0.2.0", "192.0.2.3", "192.0.2.0/30"},
+		{"192.0.2.2", "192.0.2.1", ""},
+		{"192.0.2.1", "2001:db8::1", ""},
+	}
+	for _, test := range tests {
+		start := netip.MustParseAddr(test.start)
+		end := netip.MustParseAddr(test.end)
+		if got := formatPrefixes(RangeToPrefixes(start, end)); got != test.want {
+			t.Errorf("RangeToPrefixes(%s, %s) = %s, want %s", test.start, test.end, got, test.want)
+		}
+	}
+}
+
+func TestSubtractPrefixes(t *testing.T) {
+	tests := []struct {
+		prefixes string
+		excluded string
+		want     string
+	}{
+		{"10.0.0.0/8", "", "10.0.0.0/8"},
+		{"10.0.0.0/8", "10.0.0.0/8", ""},
+		{"10.0.0.0/8", "0.0.0.0/0", ""},
+		{"10.0.0.0/8", "192.0.2.0/24", "10.0.0.0/8"},
+		{"10.0.0.0/24", "10.0.0.0/25", "10.0.0.128/25"},
+		{"10.0.0.0/24", "10.0.0.64/26", "10.0.0.0/26 10.0.0.128/25"},
+		{"10.0.0.0/30", "10.0.0.1/32 10.0.0.2/32", "10.0.0.0/32 10.0.0.3/32"},
+		{"10.0.0.0/24 10.0.1.0/24", "10.0.0.128/25 10.0.1.0/25", "10.0.0.0/25 10.0.1.128/25"},
+		{"10.0.0.0/25 10.0.0.128/25", "10.0.0.0/26", "10.0.0.64/26 10.0.0.128/25"},
+		{"2001:db8::/32", "2001:db8:8000::/33", "2001:db8::/33"},
+		{"10.0.0.0/8 2001:db8::/32", "2001:db8::/32", "10.0.0.0/8"},
+		{"10.0.0.0/8", "::/0", "10.0.0.0/8"},
+		{"", "10.0.0.0/8", ""},
+	}
+	for _, test := range tests {
+		got := formatPrefixes(SubtractPrefixes(prefixList(t, test.prefixes), prefixList(t, test.excluded)))
+		if got != test.want {
+			t.Errorf("SubtractPrefixes(%s, %s) = %s, want %s", test.prefixes, test.excluded, got, test.want)
+		}
+	}
+}
+
+func TestIntersectPrefixes(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"10.0.0.0/8", "10.0.0.0/8", "10.0.0.0/8"},
+		{"10.0.0.0/8", "10.1.0.0/16", "10.1.0.0/16"},
+		{"10.1.0.0/16", "10.0.0.0/8", "10.1.0.0/16"},
+		{"10.0.0.0/8", "192.0.2.0/24", ""},
+		{"10.0.0.0/24", "10.0.0.128/25 10.0.1.0/24", "10.0.0.128/25"},
+		{"10.0.0.0/25 10.0.0.128/25", "10.0.0.0/24", "10.0.0.0/24"},
+		{"10.0.0.0/8 2001:db8::/32", "2001:db8:1::/48 0.0.0.0/0", "10.0.0.0/8 2001:db8:1::/48"},
+		{"10.0.0.0/8", "", ""},
+	}
+	for _, test := range tests {
+		got := formatPrefixes(IntersectPrefixes(prefixList(t, test.a), prefixList(t, test.b)))
+		if got != test.want {
+			t.Errorf("IntersectPrefixes(%s, %s) = %s, want %s", test.a, test.b, got, test.want)
+		}
+	}
+}
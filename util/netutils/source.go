@@ -0,0 +1,208 @@
+package netutils
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+	"github.com/sabershahhoseini/ipset-firewall/util/file"
+	"github.com/sabershahhoseini/ipset-firewall/util/httpclient"
+	"github.com/sabershahhoseini/ipset-firewall/util/logger"
+	"github.com/sabershahhoseini/ipset-firewall/util/resolver"
+)
+
+// Result is what a Source returns after fetching its list
+type Result struct {
+	Prefixes []netip.Prefix
+	// Metadata holds provider specific information, e.g. the URLs a list was fetched from
+	Metadata map[string]string
+	// References maps entries to the reference ID the list gave them, e.g. a Spamhaus SBL id
+	References map[netip.Prefix]string
+	// Rejected counts lines that carried data but no valid network
+	Rejected int
+	// Warnings describe rejected lines, e.g. `line 12: invalid entry "foo"`,
+	// or rejected values of lists without lines, e.g. `value 3: invalid entry "foo"`
+	Warnings []string
+	// TTL is the number of seconds the entries stay valid, the shortest TTL
+	// of the records a dns source resolved. It's 0 for lists that don't expire.
+	TTL uint32
+}
+
+// maxWarnings limits how many rejected lines of a source are logged
+const maxWarnings = 10
+
+// reject counts an invalid line of a list
+func (r *Result) reject(line int, text string) {
+	r.Rejected++
+	r.Warnings = append(r.Warnings, fmt.Sprintf("line %d: invalid entry %q", line, text))
+}
+
+// rejectValue counts an invalid value of a list that has no lines, e.g. the
+// values a json path selected, by its index
+func (r *Result) rejectValue(index int, text string) {
+	r.Rejected++
+	r.Warnings = append(r.Warnings, fmt.Sprintf("value %d: invalid entry %q", index, text))
+}
+
+// Append adds the entries of other to r
+func (r *Result) Append(other Result) {
+	r.Prefixes = append(r.Prefixes, other.Prefixes...)
+	r.Rejected += other.Rejected
+	r.Warnings = append(r.Warnings, other.Warnings...)
+	if other.TTL > 0 && (r.TTL == 0 || other.TTL < r.TTL) {
+		r.TTL = other.TTL
+	}
+	for prefix, reference := range other.References {
+		if r.References == nil {
+			r.References = map[netip.Prefix]string{}
+		}
+		r.References[prefix] = reference
+	}
+	for key, value := range other.Metadata {
+		if r.Metadata == nil {
+			r.Metadata = map[string]string{}
+		}
+		r.Metadata[key] = value
+	}
+}
+
+// IPList returns the entries of r merged to CIDRs
+func (r Result) IPList() []string {
+	var ipList []string
+	for _, prefix := range r.Prefixes {
+		ipList = append(ipList, prefix.String())
+	}
+	return MergeIPsToCIDRs(ipList)
+}
+
+// Source is a provider of IP lists. New providers implement it and
+// register a SourceFactory for their type with RegisterSource.
+type Source interface {
+	Name() string
+	Fetch() (Result, error)
+}
+
+// SourceOptions are passed to every provider when it is created
+type SourceOptions struct {
+	Verbose     bool
+	LogFilePath string
+	GeoDatabase models.GeoDatabase
+	ASNDatabase string
+	Cache       models.Cache
+	// HTTP is the client of network sources, a default client is used if nil
+	HTTP *httpclient.Client
+	// Resolver is used by dns sources, a resolver using the system's nameserver if nil
+	Resolver *resolver.Resolver
+	// Notify, if set, forwards warnings the operator has to see, e.g. a list
+	// taken from the cache because it couldn't be fetched
+	Notify func(message string)
+}
+
+type SourceFactory func(config models.Source, options SourceOptions) (Source, error)
+
+var sourceRegistry = map[string]SourceFactory{}
+
+// RegisterSource makes a provider available as `source: {type: sourceType}`
+func RegisterSource(sourceType string, factory SourceFactory) {
+	if _, exists := sourceRegistry[sourceType]; exists {
+		panic("source type " + sourceType + " registered twice")
+	}
+	sourceRegistry[sourceType] = factory
+}
+
+// SourceTypes returns the sorted list of registered provider types
+func SourceTypes() []string {
+	var types []string
+	for sourceType := range sourceRegistry {
+		types = append(types, sourceType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func NewSource(config models.Source, options SourceOptions) (Source, error) {
+	factory, ok := sourceRegistry[strings.ToLower(config.Type)]
+	if !ok {
+		return nil, fmt.Errorf("unknown source type %q (available: %s)", config.Type, strings.Join(SourceTypes(), ", "))
+	}
+	return factory(config, options)
+}
+
+// LegacySource converts the country, file, url, asn and hosts fields of a rule to a
+// source definition.
+func LegacySource(rule file.Rule) models.Source {
+	if len(rule.Hosts) != 0 {
+		return models.Source{Type: "dns", Hosts: rule.Hosts, Verify: rule.Verify}
+	}
+	if len(rule.ASN) != 0 {
+		return models.Source{Type: "asn", ASN: rule.ASN, Verify: rule.Verify}
+	}
+	if len(rule.URL) != 0 {
+		return models.Source{Type: "url", Country: rule.Country, Countries: rule.Countries, URL: rule.URL, Format: rule.Format, Verify: rule.Verify}
+	}
+	if len(rule.Path) != 0 {
+		return models.Source{Type: "file", Country: rule.Country, Countries: rule.Countries, Path: rule.Path, Format: rule.Format, Verify: rule.Verify}
+	}
+	if strings.ToLower(rule.Country) == "tor" {
+		return models.Source{Type: "tor", Verify: rule.Verify}
+	}
+	return models.Source{Type: "country", Country: rule.Country, Countries: rule.Countries, Verify: rule.Verify}
+}
+
+// FetchSource creates the provider described by config and fetches its list
+func FetchSource(config models.Source, options SourceOptions) (Result, error) {
+	src, err := NewSource(config, options)
+	if err != nil {
+		return Result{}, err
+	}
+	result, err := src.Fetch()
+	if err != nil {
+		return Result{}, fmt.Errorf("source %s: %w", src.Name(), err)
+	}
+	logger.Log("Fetched "+fmt.Sprint(len(result.Prefixes))+" entries from "+src.Name(), options.LogFilePath, options.Verbose)
+	if result.Rejected > 0 {
+		logger.Log("WARNING: Rejected "+fmt.Sprint(result.Rejected)+" invalid lines from "+src.Name(), options.LogFilePath, options.Verbose)
+	}
+	for i, warning := range result.Warnings {
+		if i == maxWarnings {
+			logger.Log("WARNING: "+fmt.Sprint(len(result.Warnings)-maxWarnings)+" more invalid lines", options.LogFilePath, options.Verbose)
+			break
+		}
+		logger.Log("WARNING: "+warning, options.LogFilePath, options.Verbose)
+	}
+	return result, nil
+}
+
+// parsePrefixes parses one list entry per line, see ParseListEntry. Blank
+// lines and # comments are skipped, anything else that isn't a network is
+// rejected with a warning naming the line.
+func parsePrefixes(lines []string) Result {
+	var result Result
+	for i, line := range lines {
+		if entry, ok := result.addEntry(line); !ok {
+			result.reject(i+1, entry)
+		}
+	}
+	return result
+}
+
+// addEntry adds the networks of a list entry, skipping # comments. It returns
+// the entry without its comment, and false if it isn't blank but isn't a
+// network either, for the caller to reject it.
+func (r *Result) addEntry(entry string) (string, bool) {
+	if j := strings.Index(entry, "#"); j >= 0 {
+		entry = entry[:j]
+	}
+	entry = strings.TrimSpace(entry)
+	if entry == "" {
+		return entry, true
+	}
+	prefixes, ok := ParseListEntry(entry)
+	if !ok {
+		return entry, false
+	}
+	r.Prefixes = append(r.Prefixes, prefixes...)
+	return entry, true
+}
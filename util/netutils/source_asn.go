@@ -0,0 +1,152 @@
+package netutils
+
+import (
+	"bufio"
+	"errors"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+	"github.com/sabershahhoseini/ipset-firewall/util/file"
+	"github.com/sabershahhoseini/ipset-firewall/util/logger"
+)
+
+func init() {
+	RegisterSource("asn", newASNSource)
+}
+
+// asnSource collects every prefix originated by a list of AS numbers from a
+// local routing table dump. Supported dumps are CAIDA pfx2as files
+// (`1.0.0.0 24 13335`), `prefix asn` text files and bgpdump -m RIB exports
+// (`TABLE_DUMP2|time|B|peer|peer-as|prefix|as-path|...`).
+type asnSource struct {
+	asns     map[uint32]bool
+	database string
+	options  SourceOptions
+}
+
+func newASNSource(config models.Source, options SourceOptions) (Source, error) {
+	if len(config.ASN) == 0 {
+		return nil, errors.New("asn source requires at least one AS number")
+	}
+	asns := map[uint32]bool{}
+	for _, asn := range config.ASN {
+		number, ok := parseASN(asn)
+		if !ok {
+			return nil, errors.New("invalid AS number " + asn)
+		}
+		asns[number] = true
+	}
+	database := config.Database
+	if database == "" {
+		database = options.ASNDatabase
+	}
+	if database == "" {
+		return nil, errors.New("asn source requires a routing table dump, set asnDatabase")
+	}
+	return &asnSource{asns: asns, database: database, options: options}, nil
+}
+
+func parseASN(asn string) (uint32, bool) {
+	asn = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(asn)), "AS")
+	number, err := strconv.ParseUint(asn, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(number), true
+}
+
+func (s *asnSource) Name() string {
+	var numbers []uint32
+	for asn := range s.asns {
+		numbers = append(numbers, asn)
+	}
+	// Sorted, so the name is the same on every run
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	var asns []string
+	for _, asn := range numbers {
+		asns = append(asns, "AS"+strconv.FormatUint(uint64(asn), 10))
+	}
+	return "asn:" + strings.Join(asns, ",")
+}
+
+func (s *asnSource) Fetch() (Result, error) {
+	logger.Log("Reading routing table dump "+s.database, s.options.LogFilePath, s.options.Verbose)
+	f, err := file.OpenList(s.database)
+	if err != nil {
+		return Result{}, err
+	}
+	defer f.Close()
+
+	var prefixes []netip.Prefix
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		prefix, origins, ok := parseRouteLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		for _, origin := range origins {
+			if s.asns[origin] {
+				prefixes = append(prefixes, prefix)
+				break
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Prefixes: prefixes,
+		Metadata: map[string]string{"database": s.database},
+	}, nil
+}
+
+// parseRouteLine returns the prefix of a dump line and its origin AS numbers.
+// Multi origin entries (13335_4637), AS sets (4637,1221 or {4637,1221}) yield
+// every AS involved.
+func parseRouteLine(line string) (netip.Prefix, []uint32, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return netip.Prefix{}, nil, false
+	}
+
+	var prefixField, originField string
+	if strings.Contains(line, "|") {
+		fields := strings.Split(line, "|")
+		if len(fields) < 7 {
+			return netip.Prefix{}, nil, false
+		}
+		path := strings.Fields(fields[6])
+		if len(path) == 0 {
+			return netip.Prefix{}, nil, false
+		}
+		prefixField = fields[5]
+		originField = path[len(path)-1]
+	} else {
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 2:
+			prefixField, originField = fields[0], fields[1]
+		case 3:
+			prefixField, originField = fields[0]+"/"+fields[1], fields[2]
+		default:
+			return netip.Prefix{}, nil, false
+		}
+	}
+
+	prefix, ok := ParsePrefix(prefixField)
+	if !ok {
+		return netip.Prefix{}, nil, false
+	}
+	originField = strings.Trim(originField, "{}")
+	var origins []uint32
+	for _, asn := range strings.FieldsFunc(originField, func(r rune) bool { return r == '_' || r == ',' }) {
+		if number, ok := parseASN(asn); ok {
+			origins = append(origins, number)
+		}
+	}
+	return prefix, origins, len(origins) > 0
+}
@@ -0,0 +1,85 @@
+package netutils
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+)
+
+func TestParseRouteLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		prefix  string
+		origins string
+		ok      bool
+	}{
+		{"1.0.0.0\t24\t13335", "1.0.0.0/24", "[13335]", true},
+		{"192.0.2.0/24 AS64500", "192.0.2.0/24", "[64500]", true},
+		{"192.0.2.0 24 64500_64501", "192.0.2.0/24", "[64500 64501]", true},
+		{"192.0.2.0 24 64500,64501", "192.0.2.0/24", "[64500 64501]", true},
+		{"2001:db8::/32 {64500,64501}", "2001:db8::/32", "[64500 64501]", true},
+		{"TABLE_DUMP2|1700000000|B|198.51.100.1|64496|192.0.2.0/24|64496 64510 64500|IGP", "192.0.2.0/24", "[64500]", true},
+		{"TABLE_DUMP2|1700000000|B|198.51.100.1|64496|192.0.2.0/24|64496 {64500,64501}|IGP", "192.0.2.0/24", "[64500 64501]", true},
+		{"TABLE_DUMP2|1700000000|B|198.51.100.1|64496|192.0.2.0/24||IGP", "", "", false},
+		{"TABLE_DUMP2|1700000000|B", "", "", false},
+		{"192.0.2.0/24", "", "", false},
+		{"bad 24 64500", "", "", false},
+		{"192.0.2.0/24 ASX", "", "", false},
+		{"# 192.0.2.0/24 64500", "", "", false},
+		{"", "", "", false},
+	}
+	for _, test := range tests {
+		prefix, origins, ok := parseRouteLine(test.line)
+		if ok != test.ok {
+			t.Errorf("parseRouteLine(%q) ok = %v, want %v", test.line, ok, test.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if prefix.String() != test.prefix || fmt.Sprint(origins) != test.origins {
+			t.Errorf("parseRouteLine(%q) = %s %v, want %s %s", test.line, prefix, origins, test.prefix, test.origins)
+		}
+	}
+}
+
+func TestASNSource(t *testing.T) {
+	database := filepath.Join(t.TempDir(), "routeviews-rv2-pfx2as.txt")
+	writeTestFile(t, database, "192.0.2.0\t24\t64500\n"+
+		"198.51.100.0\t24\t64501_64500\n"+
+		"203.0.113.0\t24\t64502\n"+
+		"2001:db8::\t32\t64500\n")
+	tests := []struct {
+		asns []string
+		name string
+		want string
+	}{
+		{[]string{"AS64500"}, "asn:AS64500", "192.0.2.0/24 198.51.100.0/24 2001:db8::/32"},
+		{[]string{"64502", "as64501", "AS10"}, "asn:AS10,AS64501,AS64502", "198.51.100.0/24 203.0.113.0/24"},
+		{[]string{"AS1"}, "asn:AS1", ""},
+	}
+	for _, test := range tests {
+		source, err := NewSource(models.Source{Type: "asn", ASN: test.asns, Database: database}, SourceOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := source.Name(); got != test.name {
+			t.Errorf("Name() = %s, want %s", got, test.name)
+		}
+		result, err := source.Fetch()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := formatPrefixes(result.Prefixes); got != test.want {
+			t.Errorf("%v: prefixes = %s, want %s", test.asns, got, test.want)
+		}
+	}
+
+	for _, asns := range [][]string{nil, {"ASX"}} {
+		if _, err := NewSource(models.Source{Type: "asn", ASN: asns, Database: database}, SourceOptions{}); err == nil {
+			t.Errorf("asn source of %v succeeded, want an error", asns)
+		}
+	}
+}
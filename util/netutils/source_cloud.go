@@ -0,0 +1,348 @@
+package netutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+	"github.com/sabershahhoseini/ipset-firewall/util/file"
+	"github.com/sabershahhoseini/ipset-firewall/util/logger"
+)
+
+func init() {
+	RegisterSource("cloud", newCloudSource)
+}
+
+// cloudEntry is a network published by a cloud provider
+type cloudEntry struct {
+	prefix string
+	// services are the names a service filter is matched against
+	services []string
+	region   string
+}
+
+// cloudProvider describes where a provider publishes its ranges and how
+// they're laid out
+type cloudProvider struct {
+	urls  []string
+	parse func(body []byte) ([]cloudEntry, error)
+	// services and regions tell whether entries carry a service or region to filter on
+	services bool
+	regions  bool
+}
+
+var cloudProviders = map[string]cloudProvider{
+	"aws": {
+		urls:     []string{"https://ip-ranges.amazonaws.com/ip-ranges.json"},
+		parse:    parseAWSRanges,
+		services: true,
+		regions:  true,
+	},
+	"gcp": {
+		urls:     []string{"https://www.gstatic.com/ipranges/cloud.json"},
+		parse:    parseGCPRanges,
+		services: true,
+		regions:  true,
+	},
+	// Azure publishes its service tags under a url that changes every week
+	"azure": {
+		parse:    parseAzureRanges,
+		services: true,
+		regions:  true,
+	},
+	"cloudflare": {
+		urls:  []string{"https://www.cloudflare.com/ips-v4", "https://www.cloudflare.com/ips-v6"},
+		parse: parseCloudflareRanges,
+	},
+	"fastly": {
+		urls:  []string{"https://api.fastly.com/public-ip-list"},
+		parse: parseFastlyRanges,
+	},
+	"github": {
+		urls:     []string{"https://api.github.com/meta"},
+		parse:    parseGitHubRanges,
+		services: true,
+	},
+}
+
+// CloudProviders returns the names of the supported cloud providers
+func CloudProviders() []string {
+	var names []string
+	for name := range cloudProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// cloudSource reads the published ranges of a cloud provider from its
+// default url, or from the urls or files of the source
+type cloudSource struct {
+	provider string
+	parser   cloudProvider
+	urls     []string
+	paths    []string
+	services []string
+	regions  []string
+	verify   models.Verify
+	options  SourceOptions
+}
+
+func newCloudSource(config models.Source, options SourceOptions) (Source, error) {
+	name := strings.ToLower(config.Provider)
+	provider, ok := cloudProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown cloud provider %q, supported are %s", config.Provider, strings.Join(CloudProviders(), ", "))
+	}
+	if len(config.Service) != 0 && !provider.services {
+		return nil, errors.New("cloud provider " + name + " doesn't publish services")
+	}
+	if len(config.Region) != 0 && !provider.regions {
+		return nil, errors.New("cloud provider " + name + " doesn't publish regions")
+	}
+	urls := config.URL
+	if len(urls) == 0 && len(config.Path) == 0 {
+		urls = provider.urls
+	}
+	if len(urls) == 0 && len(config.Path) == 0 {
+		return nil, errors.New("cloud provider " + name + " requires a url or file")
+	}
+	return &cloudSource{
+		provider: name,
+		parser:   provider,
+		urls:     urls,
+		paths:    config.Path,
+		services: config.Service,
+		regions:  config.Region,
+		verify:   config.Verify,
+		options:  options,
+	}, nil
+}
+
+func (s *cloudSource) Name() string {
+	name := "cloud:" + s.provider
+	if len(s.services) != 0 {
+		name += " service=" + strings.Join(s.services, ",")
+	}
+	if len(s.regions) != 0 {
+		name += " region=" + strings.Join(s.regions, ",")
+	}
+	return name
+}
+
+func (s *cloudSource) URLs() []string {
+	return s.urls
+}
+
+// matchCloudFilter reports whether value matches any of the glob patterns,
+// ignoring case. An empty list of patterns matches everything.
+func matchCloudFilter(patterns []string, values ...string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		for _, value := range values {
+			if matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(value)); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *cloudSource) Fetch() (Result, error) {
+	var bodies [][]byte
+	for _, url := range s.urls {
+		body, err := fetchVerified(url, s.verify, s.options)
+		if err != nil {
+			return Result{}, err
+		}
+		bodies = append(bodies, body)
+	}
+	for _, filePath := range s.paths {
+		logger.Log("Reading file from "+filePath, s.options.LogFilePath, s.options.Verbose)
+		body, err := file.ReadListFile(filePath)
+		if err != nil {
+			return Result{}, err
+		}
+		bodies = append(bodies, body)
+	}
+
+	var result Result
+	for _, body := range bodies {
+		entries, err := s.parser.parse(body)
+		if err != nil {
+			return Result{}, fmt.Errorf("%s ranges: %w", s.provider, err)
+		}
+		for i, entry := range entries {
+			if !matchCloudFilter(s.services, entry.services...) || !matchCloudFilter(s.regions, entry.region) {
+				continue
+			}
+			prefix, ok := ParsePrefix(entry.prefix)
+			if !ok {
+				result.rejectValue(i, entry.prefix)
+				continue
+			}
+			result.Prefixes = append(result.Prefixes, prefix)
+		}
+	}
+	result.Metadata = map[string]string{"provider": s.provider}
+	if len(s.urls) != 0 {
+		result.Metadata["url"] = strings.Join(s.urls, " ")
+	}
+	if len(s.paths) != 0 {
+		result.Metadata["file"] = strings.Join(s.paths, " ")
+	}
+	return result, nil
+}
+
+// parseAWSRanges reads ip-ranges.json
+func parseAWSRanges(body []byte) ([]cloudEntry, error) {
+	var document struct {
+		Prefixes []struct {
+			IPPrefix string `json:"ip_prefix"`
+			Region   string `json:"region"`
+			Service  string `json:"service"`
+		} `json:"prefixes"`
+		IPv6Prefixes []struct {
+			IPv6Prefix string `json:"ipv6_prefix"`
+			Region     string `json:"region"`
+			Service    string `json:"service"`
+		} `json:"ipv6_prefixes"`
+	}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return nil, err
+	}
+	var entries []cloudEntry
+	for _, p := range document.Prefixes {
+		entries = append(entries, cloudEntry{prefix: p.IPPrefix, services: []string{p.Service}, region: p.Region})
+	}
+	for _, p := range document.IPv6Prefixes {
+		entries = append(entries, cloudEntry{prefix: p.IPv6Prefix, services: []string{p.Service}, region: p.Region})
+	}
+	return entries, nil
+}
+
+// parseGCPRanges reads cloud.json, whose scope is the region
+func parseGCPRanges(body []byte) ([]cloudEntry, error) {
+	var document struct {
+		Prefixes []struct {
+			IPv4Prefix string `json:"ipv4Prefix"`
+			IPv6Prefix string `json:"ipv6Prefix"`
+			Service    string `json:"service"`
+			Scope      string `json:"scope"`
+		} `json:"prefixes"`
+	}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return nil, err
+	}
+	var entries []cloudEntry
+	for _, p := range document.Prefixes {
+		prefix := p.IPv4Prefix
+		if prefix == "" {
+			prefix = p.IPv6Prefix
+		}
+		entries = append(entries, cloudEntry{prefix: prefix, services: []string{p.Service}, region: p.Scope})
+	}
+	return entries, nil
+}
+
+// parseAzureRanges reads a ServiceTags json file. A service filter matches
+// either the tag name, e.g. AzureFrontDoor.Frontend, or its system service.
+func parseAzureRanges(body []byte) ([]cloudEntry, error) {
+	var document struct {
+		Values []struct {
+			Name       string `json:"name"`
+			Properties struct {
+				Region          string   `json:"region"`
+				SystemService   string   `json:"systemService"`
+				AddressPrefixes []string `json:"addressPrefixes"`
+			} `json:"properties"`
+		} `json:"values"`
+	}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return nil, err
+	}
+	var entries []cloudEntry
+	for _, tag := range document.Values {
+		services := []string{tag.Name}
+		if tag.Properties.SystemService != "" {
+			services = append(services, tag.Properties.SystemService)
+		}
+		for _, prefix := range tag.Properties.AddressPrefixes {
+			entries = append(entries, cloudEntry{prefix: prefix, services: services, region: tag.Properties.Region})
+		}
+	}
+	return entries, nil
+}
+
+// parseCloudflareRanges reads the ips-v4/ips-v6 text lists or the json of
+// the /client/v4/ips api
+func parseCloudflareRanges(body []byte) ([]cloudEntry, error) {
+	var entries []cloudEntry
+	if !bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+		for _, line := range strings.Split(string(body), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				entries = append(entries, cloudEntry{prefix: line})
+			}
+		}
+		return entries, nil
+	}
+	var document struct {
+		Result struct {
+			IPv4CIDRs []string `json:"ipv4_cidrs"`
+			IPv6CIDRs []string `json:"ipv6_cidrs"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return nil, err
+	}
+	for _, prefix := range append(document.Result.IPv4CIDRs, document.Result.IPv6CIDRs...) {
+		entries = append(entries, cloudEntry{prefix: prefix})
+	}
+	return entries, nil
+}
+
+// parseFastlyRanges reads public-ip-list
+func parseFastlyRanges(body []byte) ([]cloudEntry, error) {
+	var document struct {
+		Addresses     []string `json:"addresses"`
+		IPv6Addresses []string `json:"ipv6_addresses"`
+	}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return nil, err
+	}
+	var entries []cloudEntry
+	for _, prefix := range append(document.Addresses, document.IPv6Addresses...) {
+		entries = append(entries, cloudEntry{prefix: prefix})
+	}
+	return entries, nil
+}
+
+// parseGitHubRanges reads the meta api, whose keys like hooks, web or
+// actions are the services. Keys that don't hold networks are skipped.
+func parseGitHubRanges(body []byte) ([]cloudEntry, error) {
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(body, &document); err != nil {
+		return nil, err
+	}
+	var entries []cloudEntry
+	for service, raw := range document {
+		var values []string
+		if err := json.Unmarshal(raw, &values); err != nil || len(values) == 0 {
+			continue
+		}
+		if _, ok := ParsePrefix(values[0]); !ok {
+			continue
+		}
+		for _, prefix := range values {
+			entries = append(entries, cloudEntry{prefix: prefix, services: []string{service}})
+		}
+	}
+	return entries, nil
+}
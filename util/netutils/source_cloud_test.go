@@ -0,0 +1,121 @@
+package netutils
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+)
+
+// formatCloudEntries formats entries as prefix/services/region, sorted since
+// the meta api of github is a map
+func formatCloudEntries(entries []cloudEntry) string {
+	var strs []string
+	for _, entry := range entries {
+		strs = append(strs, entry.prefix+"|"+strings.Join(entry.services, ",")+"|"+entry.region)
+	}
+	sort.Strings(strs)
+	return strings.Join(strs, " ")
+}
+
+func TestParseCloudRanges(t *testing.T) {
+	tests := []struct {
+		name  string
+		parse func(body []byte) ([]cloudEntry, error)
+		body  string
+		want  string
+	}{
+		{"aws", parseAWSRanges, `{"prefixes": [{"ip_prefix": "192.0.2.0/24", "region": "eu-west-1", "service": "EC2"}],
+			"ipv6_prefixes": [{"ipv6_prefix": "2001:db8::/32", "region": "us-east-1", "service": "S3"}]}`,
+			"192.0.2.0/24|EC2|eu-west-1 2001:db8::/32|S3|us-east-1"},
+		{"gcp", parseGCPRanges, `{"prefixes": [{"ipv4Prefix": "192.0.2.0/24", "service": "Google Cloud", "scope": "europe-west3"},
+			{"ipv6Prefix": "2001:db8::/32", "service": "Google Cloud", "scope": "us-east1"}]}`,
+			"192.0.2.0/24|Google Cloud|europe-west3 2001:db8::/32|Google Cloud|us-east1"},
+		{"azure", parseAzureRanges, `{"values": [
+			{"name": "AzureFrontDoor.Frontend", "properties": {"systemService": "AzureFrontDoor", "addressPrefixes": ["192.0.2.0/24", "2001:db8::/32"]}},
+			{"name": "Storage.WestEurope", "properties": {"region": "westeurope", "addressPrefixes": ["198.51.100.0/24"]}}]}`,
+			"192.0.2.0/24|AzureFrontDoor.Frontend,AzureFrontDoor| 198.51.100.0/24|Storage.WestEurope|westeurope 2001:db8::/32|AzureFrontDoor.Frontend,AzureFrontDoor|"},
+		{"cloudflare text", parseCloudflareRanges, "192.0.2.0/24\n\n 198.51.100.0/24 \n", "192.0.2.0/24|| 198.51.100.0/24||"},
+		{"cloudflare json", parseCloudflareRanges, `{"result": {"ipv4_cidrs": ["192.0.2.0/24"], "ipv6_cidrs": ["2001:db8::/32"]}}`,
+			"192.0.2.0/24|| 2001:db8::/32||"},
+		{"fastly", parseFastlyRanges, `{"addresses": ["192.0.2.0/24"], "ipv6_addresses": ["2001:db8::/32"]}`,
+			"192.0.2.0/24|| 2001:db8::/32||"},
+		{"github", parseGitHubRanges, `{"verifiable_password_authentication": false, "ssh_keys": ["ssh-ed25519 AAAA"],
+			"hooks": ["192.0.2.0/24"], "web": ["198.51.100.0/24", "2001:db8::/32"], "domains": {"website": ["*.github.com"]}}`,
+			"192.0.2.0/24|hooks| 198.51.100.0/24|web| 2001:db8::/32|web|"},
+	}
+	for _, test := range tests {
+		entries, err := test.parse([]byte(test.body))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := formatCloudEntries(entries); got != test.want {
+			t.Errorf("%s: %s, want %s", test.name, got, test.want)
+		}
+	}
+
+	for _, parse := range []func(body []byte) ([]cloudEntry, error){parseAWSRanges, parseGCPRanges, parseAzureRanges, parseFastlyRanges, parseGitHubRanges} {
+		if _, err := parse([]byte("<html>")); err == nil {
+			t.Errorf("parsing html succeeded")
+		}
+	}
+}
+
+func TestMatchCloudFilter(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		values   []string
+		want     bool
+	}{
+		{nil, []string{"EC2"}, true},
+		{nil, nil, true},
+		{[]string{"ec2"}, []string{"EC2"}, true},
+		{[]string{"EC2"}, []string{"S3"}, false},
+		{[]string{"S3", "EC2"}, []string{"EC2"}, true},
+		{[]string{"eu-*"}, []string{"eu-west-1"}, true},
+		{[]string{"eu-*"}, []string{"us-east-1"}, false},
+		{[]string{"AzureFrontDoor.*"}, []string{"AzureFrontDoor.Frontend", "AzureFrontDoor"}, true},
+		{[]string{"azurefrontdoor"}, []string{"AzureFrontDoor.Frontend", "AzureFrontDoor"}, true},
+		{[]string{"EC2"}, nil, false},
+	}
+	for _, test := range tests {
+		if got := matchCloudFilter(test.patterns, test.values...); got != test.want {
+			t.Errorf("matchCloudFilter(%v, %v) = %v, want %v", test.patterns, test.values, got, test.want)
+		}
+	}
+}
+
+func TestCloudSource(t *testing.T) {
+	ranges := filepath.Join(t.TempDir(), "ip-ranges.json")
+	writeTestFile(t, ranges, `{"prefixes": [
+		{"ip_prefix": "192.0.2.0/24", "region": "eu-west-1", "service": "EC2"},
+		{"ip_prefix": "198.51.100.0/24", "region": "us-east-1", "service": "EC2"},
+		{"ip_prefix": "203.0.113.0/24", "region": "eu-central-1", "service": "S3"},
+		{"ip_prefix": "not-a-prefix", "region": "eu-west-1", "service": "EC2"}],
+		"ipv6_prefixes": [{"ipv6_prefix": "2001:db8::/32", "region": "eu-west-1", "service": "EC2"}]}`)
+	source := models.Source{Type: "cloud", Provider: "AWS", Path: []string{ranges}, Service: []string{"ec2"}, Region: []string{"eu-*"}}
+	result, err := FetchSource(source, SourceOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatPrefixes(result.Prefixes); got != "192.0.2.0/24 2001:db8::/32" {
+		t.Errorf("prefixes = %s, want 192.0.2.0/24 2001:db8::/32", got)
+	}
+	if result.Rejected != 1 || strings.Join(result.Warnings, "; ") != `value 3: invalid entry "not-a-prefix"` {
+		t.Errorf("rejected %d %v, want 1 [value 3: invalid entry \"not-a-prefix\"]", result.Rejected, result.Warnings)
+	}
+
+	for _, source := range []models.Source{
+		{Type: "cloud", Provider: "oracle"},
+		{Type: "cloud", Provider: "azure"},
+		{Type: "cloud", Provider: "cloudflare", Service: []string{"cdn"}},
+		{Type: "cloud", Provider: "github", Region: []string{"eu"}},
+	} {
+		if _, err := NewSource(source, SourceOptions{}); err == nil {
+			t.Errorf("NewSource(%+v) succeeded", source)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package netutils
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+)
+
+func init() {
+	RegisterSource("country", newCountrySource)
+}
+
+// countrySource fetches the IPv4 and IPv6 lists of countries from github
+type countrySource struct {
+	countryCodes []string
+	verify       models.Verify
+	options      SourceOptions
+}
+
+func newCountrySource(config models.Source, options SourceOptions) (Source, error) {
+	countries := sourceCountries(config)
+	if len(countries) == 0 {
+		return nil, errors.New("country source requires a country code")
+	}
+	// A local database takes precedence over github
+	if config.Database == "" {
+		config.Database = options.GeoDatabase.Path
+		config.Locations = options.GeoDatabase.Locations
+	}
+	if config.Database != "" {
+		return newGeoIPSource(config, options)
+	}
+	for i := range countries {
+		countries[i] = strings.ToLower(countries[i])
+	}
+	return &countrySource{countryCodes: countries, verify: config.Verify, options: options}, nil
+}
+
+func (s *countrySource) Name() string {
+	return "country:" + strings.Join(s.countryCodes, ",")
+}
+
+// URLs returns the IPv4 and IPv6 list of every country
+func (s *countrySource) URLs() []string {
+	var urls []string
+	for _, countryCode := range s.countryCodes {
+		urls = append(urls, strings.Replace(GeoURL, "COUNTRY_CODE", countryCode, 1),
+			strings.Replace(GeoURL6, "COUNTRY_CODE", countryCode, 1))
+	}
+	return urls
+}
+
+func (s *countrySource) Fetch() (Result, error) {
+	var lines []string
+	urls := s.URLs()
+	for i := 0; i < len(urls); i += 2 {
+		url, url6 := urls[i], urls[i+1]
+
+		lines4, err := fetchLines(url, s.verify, s.options)
+		if err != nil {
+			return Result{}, err
+		}
+		// Not every country has IPv6 networks assigned
+		lines6, err := fetchLines(url6, s.verify, s.options)
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+			err = nil
+		}
+		if err != nil {
+			return Result{}, err
+		}
+		lines = append(append(lines, lines4...), lines6...)
+	}
+
+	result := parsePrefixes(lines)
+	result.Metadata = map[string]string{"url": strings.Join(urls, " ")}
+	return result, nil
+}
@@ -0,0 +1,49 @@
+package netutils
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+	"github.com/sabershahhoseini/ipset-firewall/util/logger"
+)
+
+func init() {
+	RegisterSource("dns", newDNSSource)
+}
+
+// dnsSource resolves the A and AAAA records of hostnames
+type dnsSource struct {
+	hosts   []string
+	options SourceOptions
+}
+
+func newDNSSource(config models.Source, options SourceOptions) (Source, error) {
+	if len(config.Hosts) == 0 {
+		return nil, errors.New("dns source requires at least one host")
+	}
+	return &dnsSource{hosts: config.Hosts, options: options}, nil
+}
+
+func (s *dnsSource) Name() string {
+	return "dns:" + strings.Join(s.hosts, ",")
+}
+
+func (s *dnsSource) Fetch() (Result, error) {
+	result := Result{Metadata: map[string]string{"server": s.options.Resolver.Server()}}
+	for _, host := range s.hosts {
+		records, err := s.options.Resolver.Lookup(host)
+		if err != nil {
+			return Result{}, err
+		}
+		for _, record := range records {
+			logger.Log("Resolved "+host+" to "+record.Addr.String(), s.options.LogFilePath, s.options.Verbose)
+			result.Prefixes = append(result.Prefixes, netip.PrefixFrom(record.Addr, record.Addr.BitLen()))
+			if record.TTL > 0 && (result.TTL == 0 || record.TTL < result.TTL) {
+				result.TTL = record.TTL
+			}
+		}
+	}
+	return result, nil
+}
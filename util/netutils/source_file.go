@@ -0,0 +1,70 @@
+package netutils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+	"github.com/sabershahhoseini/ipset-firewall/util/file"
+	"github.com/sabershahhoseini/ipset-firewall/util/logger"
+)
+
+func init() {
+	RegisterSource("file", newFileSource)
+}
+
+// fileSource reads networks from local list files, one per line. Paths may be
+// globs or directories, files may be gzip compressed and "-" reads from stdin.
+type fileSource struct {
+	paths   []string
+	format  models.Format
+	options SourceOptions
+}
+
+func newFileSource(config models.Source, options SourceOptions) (Source, error) {
+	if len(config.Path) == 0 {
+		return nil, errors.New("file source requires at least one file")
+	}
+	return &fileSource{paths: config.Path, format: config.Format, options: options}, nil
+}
+
+func (s *fileSource) Name() string {
+	return "file:" + strings.Join(s.paths, ",")
+}
+
+func (s *fileSource) Fetch() (Result, error) {
+	var result Result
+	var paths []string
+	// Globs and directories are expanded on every fetch, so new files are picked up
+	for _, pattern := range s.paths {
+		expanded, err := file.ExpandListPaths([]string{pattern})
+		if err != nil {
+			return Result{}, err
+		}
+		if len(expanded) == 0 {
+			logger.Log("WARNING: No list files found for "+pattern, s.options.LogFilePath, s.options.Verbose)
+		}
+		paths = append(paths, expanded...)
+	}
+	for _, path := range paths {
+		logger.Log("Reading file from "+path, s.options.LogFilePath, s.options.Verbose)
+		body, err := file.ReadListFile(path)
+		if err != nil {
+			return Result{}, err
+		}
+		parsed, err := ParseList(body, s.format)
+		if err != nil {
+			return Result{}, fmt.Errorf("%s: %w", path, err)
+		}
+		for i, warning := range parsed.Warnings {
+			parsed.Warnings[i] = path + ": " + warning
+		}
+		result.Append(parsed)
+	}
+	if result.Metadata == nil {
+		result.Metadata = map[string]string{}
+	}
+	result.Metadata["file"] = strings.Join(paths, " ")
+	return result, nil
+}
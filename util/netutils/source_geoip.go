@@ -0,0 +1,279 @@
+package netutils
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/sabershahhoseini/ipset-firewall/models"
+	"github.com/sabershahhoseini/ipset-firewall/util/logger"
+)
+
+func init() {
+	RegisterSource("geoip", newGeoIPSource)
+}
+
+// geoIPSource extracts the networks of countries from a local GeoLite2 or
+// DB-IP database. Supported are mmdb files, GeoLite2 "Blocks" csv files
+// together with their "Locations" csv, and DB-IP "start,end,country" csv files.
+type geoIPSource struct {
+	countries   map[string]bool
+	countryList string
+	database    string
+	locations   string
+	options     SourceOptions
+}
+
+func newGeoIPSource(config models.Source, options SourceOptions) (Source, error) {
+	countries := sourceCountries(config)
+	if len(countries) == 0 {
+		return nil, errors.New("geoip source requires a country code")
+	}
+	if config.Database == "" {
+		config.Database = options.GeoDatabase.Path
+		config.Locations = options.GeoDatabase.Locations
+	}
+	if config.Database == "" {
+		return nil, errors.New("geoip source requires a database")
+	}
+	return &geoIPSource{
+		countries:   countrySet(countries),
+		countryList: strings.ToLower(strings.Join(countries, ",")),
+		database:    config.Database,
+		locations:   config.Locations,
+		options:     options,
+	}, nil
+}
+
+func (s *geoIPSource) Name() string {
+	return "geoip:" + s.countryList + "@" + s.database
+}
+
+func (s *geoIPSource) Fetch() (Result, error) {
+	logger.Log("Reading country database "+s.database, s.options.LogFilePath, s.options.Verbose)
+	info, err := os.Stat(s.database)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var prefixes []netip.Prefix
+	switch {
+	case info.IsDir():
+		prefixes, err = s.readGeoLite2Dir()
+	case strings.HasSuffix(strings.ToLower(s.database), ".mmdb"):
+		prefixes, err = s.readMMDB()
+	default:
+		prefixes, err = s.readCSV(s.database)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Prefixes: prefixes,
+		Metadata: map[string]string{"database": s.database},
+	}, nil
+}
+
+func (s *geoIPSource) readMMDB() ([]netip.Prefix, error) {
+	db, err := maxminddb.Open(s.database)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+		RegisteredCountry struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"registered_country"`
+	}
+	var prefixes []netip.Prefix
+	networks := db.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		record.Country.ISOCode = ""
+		record.RegisteredCountry.ISOCode = ""
+		network, err := networks.Network(&record)
+		if err != nil {
+			return nil, err
+		}
+		isoCode := record.Country.ISOCode
+		if isoCode == "" {
+			isoCode = record.RegisteredCountry.ISOCode
+		}
+		if !s.countries[strings.ToUpper(isoCode)] {
+			continue
+		}
+		if prefix, ok := ParsePrefix(network.String()); ok {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes, networks.Err()
+}
+
+// readGeoLite2Dir reads the Blocks-IPv4 and Blocks-IPv6 files of an
+// extracted GeoLite2-Country-CSV archive
+func (s *geoIPSource) readGeoLite2Dir() ([]netip.Prefix, error) {
+	blocks, err := filepath.Glob(filepath.Join(s.database, "*Blocks-IPv[46].csv"))
+	if err != nil {
+		return nil, err
+	}
+	if len(blocks) == 0 {
+		return nil, errors.New("no GeoLite2 Blocks csv files in " + s.database)
+	}
+	var prefixes []netip.Prefix
+	for _, path := range blocks {
+		p, err := s.readCSV(path)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p...)
+	}
+	return prefixes, nil
+}
+
+func (s *geoIPSource) readCSV(path string) ([]netip.Prefix, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	first, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(first) > 0 && first[0] == "network" {
+		return s.readGeoLite2Blocks(path, first, reader)
+	}
+	return s.readDBIP(path, first, reader)
+}
+
+// readGeoLite2Blocks matches the geoname ids of a GeoLite2 Blocks file
+// against the ids the locations file maps to the countries
+func (s *geoIPSource) readGeoLite2Blocks(path string, header []string, reader *csv.Reader) ([]netip.Prefix, error) {
+	geonameIDs, err := s.readGeoLite2Locations(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	geonameColumn := csvColumn(header, "geoname_id")
+	registeredColumn := csvColumn(header, "registered_country_geoname_id")
+	if geonameColumn < 0 {
+		return nil, errors.New(path + ": missing geoname_id column")
+	}
+
+	var prefixes []netip.Prefix
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		id := csvField(record, geonameColumn)
+		if id == "" {
+			id = csvField(record, registeredColumn)
+		}
+		if !geonameIDs[id] {
+			continue
+		}
+		if prefix, ok := ParsePrefix(record[0]); ok {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes, nil
+}
+
+func (s *geoIPSource) readGeoLite2Locations(dir string) (map[string]bool, error) {
+	path := s.locations
+	if path == "" {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*Locations-en.csv"))
+		if len(matches) == 0 {
+			return nil, errors.New("no GeoLite2 Locations csv file found, set locations")
+		}
+		path = matches[0]
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	idColumn := csvColumn(header, "geoname_id")
+	isoColumn := csvColumn(header, "country_iso_code")
+	if idColumn < 0 || isoColumn < 0 {
+		return nil, errors.New(path + ": missing geoname_id or country_iso_code column")
+	}
+
+	geonameIDs := map[string]bool{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if s.countries[strings.ToUpper(csvField(record, isoColumn))] {
+			geonameIDs[csvField(record, idColumn)] = true
+		}
+	}
+	return geonameIDs, nil
+}
+
+// readDBIP reads DB-IP country csv files, laid out as start,end,country
+func (s *geoIPSource) readDBIP(path string, first []string, reader *csv.Reader) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	record := first
+	for {
+		if len(record) >= 3 && s.countries[strings.ToUpper(record[2])] {
+			start, errStart := netip.ParseAddr(record[0])
+			end, errEnd := netip.ParseAddr(record[1])
+			if errStart == nil && errEnd == nil {
+				prefixes = append(prefixes, RangeToPrefixes(start, end)...)
+			}
+		}
+		var err error
+		record, err = reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return prefixes, nil
+}
+
+func csvColumn(header []string, name string) int {
+	for i, field := range header {
+		if field == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func csvField(record []string, column int) string {
+	if column < 0 || column >= len(record) {
+		return ""
+	}
+	return record[column]
+}
@@ -0,0 +1,104 @@
+package netutils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+)
+
+func writeTestFile(t *testing.T, path string, body string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGeoIPSourceDBIP(t *testing.T) {
+	database := filepath.Join(t.TempDir(), "dbip-country-lite.csv")
+	writeTestFile(t, database, "192.0.2.0,192.0.2.255,IR\n"+
+		"198.51.100.0,198.51.100.255,DE\n"+
+		"203.0.113.1,203.0.113.2,ir\n"+
+		"bad,203.0.113.9,IR\n"+
+		"2001:db8::,2001:db8::ffff,IR\n")
+	tests := []struct {
+		countries []string
+		want      string
+	}{
+		{[]string{"ir"}, "192.0.2.0/24 203.0.113.1/32 203.0.113.2/32 2001:db8::/112"},
+		{[]string{"DE", "IR"}, "192.0.2.0/24 198.51.100.0/24 203.0.113.1/32 203.0.113.2/32 2001:db8::/112"},
+		{[]string{"fr"}, ""},
+	}
+	for _, test := range tests {
+		result, err := FetchSource(models.Source{Type: "geoip", Countries: test.countries, Database: database}, SourceOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := formatPrefixes(result.Prefixes); got != test.want {
+			t.Errorf("countries %v: %s, want %s", test.countries, got, test.want)
+		}
+	}
+}
+
+func TestGeoIPSourceGeoLite2(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "GeoLite2-Country-Locations-en.csv"),
+		"geoname_id,locale_code,continent_code,continent_name,country_iso_code,country_name,is_in_european_union\n"+
+			"130758,en,AS,Asia,IR,Iran,0\n"+
+			"2921044,en,EU,Europe,DE,Germany,1\n")
+	writeTestFile(t, filepath.Join(dir, "GeoLite2-Country-Blocks-IPv4.csv"),
+		"network,geoname_id,registered_country_geoname_id,represented_country_geoname_id,is_anonymous_proxy,is_satellite_provider\n"+
+			"192.0.2.0/24,130758,130758,,0,0\n"+
+			"198.51.100.0/24,2921044,2921044,,0,0\n"+
+			"203.0.113.0/24,,130758,,0,0\n")
+	writeTestFile(t, filepath.Join(dir, "GeoLite2-Country-Blocks-IPv6.csv"),
+		"network,geoname_id,registered_country_geoname_id,represented_country_geoname_id,is_anonymous_proxy,is_satellite_provider\n"+
+			"2001:db8::/32,130758,130758,,0,0\n")
+
+	result, err := FetchSource(models.Source{Type: "geoip", Country: "ir", Database: dir}, SourceOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := formatPrefixes(AggregatePrefixes(result.Prefixes)), "192.0.2.0/24 203.0.113.0/24 2001:db8::/32"; got != want {
+		t.Errorf("prefixes = %s, want %s", got, want)
+	}
+
+	// A single Blocks file finds the Locations file next to it
+	result, err = FetchSource(models.Source{Type: "geoip", Country: "de",
+		Database: filepath.Join(dir, "GeoLite2-Country-Blocks-IPv4.csv")}, SourceOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatPrefixes(result.Prefixes); got != "198.51.100.0/24" {
+		t.Errorf("prefixes = %s, want 198.51.100.0/24", got)
+	}
+}
+
+func TestGeoIPSourceErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []models.Source{
+		{Type: "geoip", Database: filepath.Join(dir, "missing.csv"), Country: "ir"},
+		{Type: "geoip", Database: dir, Country: "ir"},
+		{Type: "geoip", Country: "ir"},
+		{Type: "geoip", Database: dir},
+	}
+	for _, source := range tests {
+		if _, err := FetchSource(source, SourceOptions{}); err == nil {
+			t.Errorf("FetchSource(%+v) succeeded, want an error", source)
+		}
+	}
+}
+
+func TestCountrySourceUsesGeoDatabase(t *testing.T) {
+	database := filepath.Join(t.TempDir(), "dbip.csv")
+	writeTestFile(t, database, "192.0.2.0,192.0.2.255,IR\n")
+	options := SourceOptions{GeoDatabase: models.GeoDatabase{Path: database}}
+	source, err := NewSource(models.Source{Type: "country", Country: "ir"}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := source.(*geoIPSource); !ok {
+		t.Errorf("country source with a geoDatabase is %T, want *geoIPSource", source)
+	}
+}
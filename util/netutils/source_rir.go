@@ -0,0 +1,142 @@
+package netutils
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+	"github.com/sabershahhoseini/ipset-firewall/util/logger"
+)
+
+func init() {
+	RegisterSource("rir", newRIRSource)
+}
+
+// rirSource reads the networks of countries from locally stored RIR
+// delegated statistics files (delegated-<rir>-extended-latest). Records look like
+// registry|cc|type|start|value|date|status[|opaque-id]
+type rirSource struct {
+	countries   map[string]bool
+	countryList string
+	paths       []string
+	status      map[string]bool
+	options     SourceOptions
+}
+
+func newRIRSource(config models.Source, options SourceOptions) (Source, error) {
+	countries := sourceCountries(config)
+	if len(countries) == 0 {
+		return nil, errors.New("rir source requires a country code")
+	}
+	if len(config.Path) == 0 {
+		return nil, errors.New("rir source requires at least one delegated file")
+	}
+	statusList := config.Status
+	if len(statusList) == 0 {
+		statusList = []string{"allocated", "assigned"}
+	}
+	status := map[string]bool{}
+	for _, st := range statusList {
+		status[strings.ToLower(st)] = true
+	}
+	return &rirSource{
+		countries:   countrySet(countries),
+		countryList: strings.ToLower(strings.Join(countries, ",")),
+		paths:       config.Path,
+		status:      status,
+		options:     options,
+	}, nil
+}
+
+func (s *rirSource) Name() string {
+	return "rir:" + s.countryList
+}
+
+func (s *rirSource) Fetch() (Result, error) {
+	var result Result
+	for _, path := range s.paths {
+		logger.Log("Reading delegated file "+path, s.options.LogFilePath, s.options.Verbose)
+		err := s.readDelegated(path, &result)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+	result.Metadata = map[string]string{"file": strings.Join(s.paths, " ")}
+	return result, nil
+}
+
+// readDelegated adds the networks of the countries in the delegated file at
+// path to result. Invalid records of the countries are rejected.
+func (s *rirSource) readDelegated(path string, result *Result) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		// Skip the version line and summary lines
+		if len(fields) < 7 || fields[1] == "*" {
+			continue
+		}
+		if fields[2] != "ipv4" && fields[2] != "ipv6" {
+			continue
+		}
+		if !s.countries[strings.ToUpper(fields[1])] || !s.status[strings.ToLower(fields[6])] {
+			continue
+		}
+		p, err := delegatedRecordPrefixes(fields[2], fields[3], fields[4])
+		if err != nil {
+			result.reject(lineNumber, line)
+			continue
+		}
+		result.Prefixes = append(result.Prefixes, p...)
+	}
+	return scanner.Err()
+}
+
+// delegatedRecordPrefixes converts an ipv4 start+count or an ipv6
+// start+prefix length record to CIDRs
+func delegatedRecordPrefixes(recordType string, start string, value string) ([]netip.Prefix, error) {
+	addr, err := netip.ParseAddr(start)
+	if err != nil {
+		return nil, err
+	}
+	if recordType == "ipv4" {
+		count, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || count == 0 || !addr.Is4() {
+			return nil, fmt.Errorf("invalid ipv4 record %s|%s", start, value)
+		}
+		first := addr.As4()
+		last := uint64(binary.BigEndian.Uint32(first[:])) + count - 1
+		if last > 0xffffffff {
+			return nil, fmt.Errorf("ipv4 record %s|%s exceeds the address space", start, value)
+		}
+		var end [4]byte
+		binary.BigEndian.PutUint32(end[:], uint32(last))
+		return RangeToPrefixes(addr, netip.AddrFrom4(end)), nil
+	}
+	bits, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, err
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return nil, err
+	}
+	return []netip.Prefix{prefix}, nil
+}
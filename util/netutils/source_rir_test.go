@@ -0,0 +1,87 @@
+package netutils
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+)
+
+func TestDelegatedRecordPrefixes(t *testing.T) {
+	tests := []struct {
+		recordType, start, value string
+		want                     string
+		ok                       bool
+	}{
+		{"ipv4", "192.0.2.0", "256", "192.0.2.0/24", true},
+		{"ipv4", "192.0.2.0", "1", "192.0.2.0/32", true},
+		{"ipv4", "192.0.2.0", "768", "192.0.2.0/23 192.0.4.0/24", true},
+		{"ipv4", "255.255.255.0", "256", "255.255.255.0/24", true},
+		{"ipv4", "255.255.255.0", "257", "", false},
+		{"ipv4", "192.0.2.0", "0", "", false},
+		{"ipv4", "192.0.2.0", "many", "", false},
+		{"ipv4", "2001:db8::", "256", "", false},
+		{"ipv6", "2001:db8::", "32", "2001:db8::/32", true},
+		{"ipv6", "2001:db8::", "129", "", false},
+		{"ipv6", "bad", "32", "", false},
+	}
+	for _, test := range tests {
+		prefixes, err := delegatedRecordPrefixes(test.recordType, test.start, test.value)
+		if (err == nil) != test.ok {
+			t.Errorf("delegatedRecordPrefixes(%s, %s, %s) error = %v, want ok %v", test.recordType, test.start, test.value, err, test.ok)
+			continue
+		}
+		if got := formatPrefixes(prefixes); test.ok && got != test.want {
+			t.Errorf("delegatedRecordPrefixes(%s, %s, %s) = %s, want %s", test.recordType, test.start, test.value, got, test.want)
+		}
+	}
+}
+
+func TestRIRSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "delegated-ripencc-extended-latest")
+	writeTestFile(t, path, "2|ripencc|20240101|5|19830705|20240101|+0100\n"+
+		"ripencc|*|ipv4|*|3|summary\n"+
+		"# comment\n"+
+		"ripencc|IR|ipv4|192.0.2.0|256|20100101|allocated|x\n"+
+		"ripencc|IR|ipv4|198.51.100.0|256|20100101|reserved|x\n"+
+		"ripencc|DE|ipv4|203.0.113.0|256|20100101|allocated|x\n"+
+		"ripencc|IR|ipv6|2001:db8::|32|20100101|assigned|x\n"+
+		"ripencc|IR|asn|44244|1|20100101|allocated|x\n"+
+		"ripencc|IR|ipv4|bad|256|20100101|allocated|x\n")
+	tests := []struct {
+		source   models.Source
+		want     string
+		warnings string
+	}{
+		{
+			source:   models.Source{Type: "rir", Country: "ir", Path: []string{path}},
+			want:     "192.0.2.0/24 2001:db8::/32",
+			warnings: `line 9: invalid entry "ripencc|IR|ipv4|bad|256|20100101|allocated|x"`,
+		},
+		{
+			source:   models.Source{Type: "rir", Country: "ir", Path: []string{path}, Status: []string{"reserved"}},
+			want:     "198.51.100.0/24",
+			warnings: "",
+		},
+		{
+			source: models.Source{Type: "rir", Countries: []string{"de"}, Path: []string{path}},
+			want:   "203.0.113.0/24",
+		},
+	}
+	for _, test := range tests {
+		result, err := FetchSource(test.source, SourceOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := formatPrefixes(result.Prefixes); got != test.want {
+			t.Errorf("%+v: prefixes = %s, want %s", test.source, got, test.want)
+		}
+		if got := strings.Join(result.Warnings, "; "); got != test.warnings {
+			t.Errorf("%+v: warnings = %s, want %s", test.source, got, test.warnings)
+		}
+		if result.Rejected != len(result.Warnings) {
+			t.Errorf("%+v: rejected = %d, want %d", test.source, result.Rejected, len(result.Warnings))
+		}
+	}
+}
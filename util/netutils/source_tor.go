@@ -0,0 +1,37 @@
+package netutils
+
+import (
+	"github.com/sabershahhoseini/ipset-firewall/models"
+)
+
+func init() {
+	RegisterSource("tor", newTorSource)
+}
+
+// torSource fetches the list of Tor exit nodes
+type torSource struct {
+	verify  models.Verify
+	options SourceOptions
+}
+
+func newTorSource(config models.Source, options SourceOptions) (Source, error) {
+	return &torSource{verify: config.Verify, options: options}, nil
+}
+
+func (s *torSource) Name() string {
+	return "tor"
+}
+
+func (s *torSource) URLs() []string {
+	return []string{TorURL}
+}
+
+func (s *torSource) Fetch() (Result, error) {
+	lines, err := fetchLines(TorURL, s.verify, s.options)
+	if err != nil {
+		return Result{}, err
+	}
+	result := parsePrefixes(lines)
+	result.Metadata = map[string]string{"url": TorURL}
+	return result, nil
+}
@@ -0,0 +1,56 @@
+package netutils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+)
+
+func init() {
+	RegisterSource("url", newURLSource)
+}
+
+// urlSource fetches lists from arbitrary URLs and parses them with format
+type urlSource struct {
+	urls    []string
+	format  models.Format
+	verify  models.Verify
+	options SourceOptions
+}
+
+func newURLSource(config models.Source, options SourceOptions) (Source, error) {
+	if len(config.URL) == 0 {
+		return nil, errors.New("url source requires at least one url")
+	}
+	return &urlSource{urls: config.URL, format: config.Format, verify: config.Verify, options: options}, nil
+}
+
+func (s *urlSource) Name() string {
+	return "url:" + strings.Join(s.urls, ",")
+}
+
+func (s *urlSource) URLs() []string {
+	return s.urls
+}
+
+func (s *urlSource) Fetch() (Result, error) {
+	var result Result
+	for _, url := range s.urls {
+		body, err := fetchVerified(url, s.verify, s.options)
+		if err != nil {
+			return Result{}, err
+		}
+		parsed, err := ParseList(body, s.format)
+		if err != nil {
+			return Result{}, fmt.Errorf("%s: %w", url, err)
+		}
+		result.Append(parsed)
+	}
+	if result.Metadata == nil {
+		result.Metadata = map[string]string{}
+	}
+	result.Metadata["url"] = strings.Join(s.urls, " ")
+	return result, nil
+}
@@ -0,0 +1,71 @@
+package netutils
+
+import "net/netip"
+
+// PrefixTrie is a binary trie of prefixes of both families, each inserted
+// with a value. It finds all prefixes containing an address in one walk
+// from the root. The zero value is an empty trie.
+type PrefixTrie struct {
+	v4 *trieNode
+	v6 *trieNode
+}
+
+type trieNode struct {
+	children [2]*trieNode
+	// values of the prefixes ending at this node
+	values []int
+}
+
+// TrieMatch is a prefix containing an address and the value it was inserted with
+type TrieMatch struct {
+	Prefix netip.Prefix
+	Value  int
+}
+
+func (t *PrefixTrie) root(addr netip.Addr) **trieNode {
+	if addr.Is4() {
+		return &t.v4
+	}
+	return &t.v6
+}
+
+// addrBit returns bit i of addr, counting from the most significant one
+func addrBit(addr []byte, i int) int {
+	return int(addr[i/8]>>(7-i%8)) & 1
+}
+
+// Insert adds prefix with value. A prefix may be inserted with several values.
+func (t *PrefixTrie) Insert(prefix netip.Prefix, value int) {
+	prefix = prefix.Masked()
+	addr := prefix.Addr().AsSlice()
+	node := t.root(prefix.Addr())
+	for i := 0; ; i++ {
+		if *node == nil {
+			*node = &trieNode{}
+		}
+		if i == prefix.Bits() {
+			break
+		}
+		node = &(*node).children[addrBit(addr, i)]
+	}
+	(*node).values = append((*node).values, value)
+}
+
+// Lookup returns the prefixes containing addr, shortest first
+func (t *PrefixTrie) Lookup(addr netip.Addr) []TrieMatch {
+	addr = addr.Unmap()
+	raw := addr.AsSlice()
+	var matches []TrieMatch
+	node := *t.root(addr)
+	for i := 0; node != nil; i++ {
+		for _, value := range node.values {
+			prefix, _ := addr.Prefix(i)
+			matches = append(matches, TrieMatch{Prefix: prefix, Value: value})
+		}
+		if i == addr.BitLen() {
+			break
+		}
+		node = node.children[addrBit(raw, i)]
+	}
+	return matches
+}
@@ -0,0 +1,273 @@
+package netutils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/sabershahhoseini/ipset-firewall/models"
+	"github.com/sabershahhoseini/ipset-firewall/util/logger"
+	"golang.org/x/crypto/blake2b"
+)
+
+// VerificationError is returned when a downloaded list fails its integrity checks
+type VerificationError struct {
+	URL string
+	Err error
+}
+
+func (e *VerificationError) Error() string {
+	return "verification of " + e.URL + " failed: " + e.Err.Error()
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+func verifyEnabled(verify models.Verify) bool {
+	return verify.SHA256 != "" || verify.ChecksumURL != "" || verify.Signature != ""
+}
+
+// fetchVerified downloads url and checks it against verify. Lists failing
+// any of the configured checks are never returned.
+func fetchVerified(url string, verify models.Verify, options SourceOptions) ([]byte, error) {
+	body, err := httpGet(url, options)
+	if err != nil {
+		return nil, err
+	}
+	if !verifyEnabled(verify) {
+		return body, nil
+	}
+	if err := verifyList(url, body, verify, options); err != nil {
+		return nil, &VerificationError{URL: url, Err: err}
+	}
+	logger.Log("Verified "+url, options.LogFilePath, options.Verbose)
+	return body, nil
+}
+
+func verifyList(url string, body []byte, verify models.Verify, options SourceOptions) error {
+	sum := sha256.Sum256(body)
+	digest := hex.EncodeToString(sum[:])
+
+	if verify.SHA256 != "" && !strings.EqualFold(verify.SHA256, digest) {
+		return fmt.Errorf("sha256 is %s, expected %s", digest, verify.SHA256)
+	}
+	if verify.ChecksumURL != "" {
+		checksums, err := httpGet(verify.ChecksumURL, options)
+		if err != nil {
+			return fmt.Errorf("fetching checksum: %w", err)
+		}
+		var expected string
+		for _, name := range checksumNames(url, verify.ChecksumURL) {
+			expected, err = findChecksum(checksums, name)
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(expected, digest) {
+			return fmt.Errorf("sha256 is %s, checksum file says %s", digest, expected)
+		}
+	}
+
+	switch strings.ToLower(verify.Signature) {
+	case "":
+		return nil
+	case "minisign":
+		signature, err := fetchSignature(url, verify, ".minisig", options)
+		if err != nil {
+			return err
+		}
+		return verifyMinisign(body, signature, verify.PublicKey)
+	case "pgp", "openpgp", "gpg":
+		signature, err := fetchSignature(url, verify, ".asc", options)
+		if err != nil {
+			return err
+		}
+		return verifyPGP(body, signature, verify.PublicKey)
+	}
+	return fmt.Errorf("unknown signature type %q", verify.Signature)
+}
+
+func fetchSignature(url string, verify models.Verify, suffix string, options SourceOptions) ([]byte, error) {
+	if verify.PublicKey == "" {
+		return nil, errors.New("signature verification requires a publicKey")
+	}
+	signatureURL := verify.SignatureURL
+	if signatureURL == "" {
+		signatureURL = url + suffix
+	}
+	signature, err := httpGet(signatureURL, options)
+	if err != nil {
+		return nil, fmt.Errorf("fetching signature: %w", err)
+	}
+	return signature, nil
+}
+
+// checksumNames returns the names url may be listed under in the checksum
+// file at checksumURL: its path relative to the checksum file, so lists of
+// the same name in different directories get their own digest, then its
+// base name
+func checksumNames(url string, checksumURL string) []string {
+	base := path.Base(url)
+	dir := checksumURL[:strings.LastIndex(checksumURL, "/")+1]
+	if dir == "" || !strings.HasPrefix(url, dir) || url[len(dir):] == base {
+		return []string{base}
+	}
+	return []string{url[len(dir):], base}
+}
+
+// URLSource is implemented by sources that download their lists. URLs
+// returns the lists the source downloads, each verified on its own.
+type URLSource interface {
+	URLs() []string
+}
+
+// ValidateVerify checks the verify settings of a source. Sources that don't
+// download a list can't verify it, so verify settings are refused instead of
+// ignored. A sha256 or signatureURL can only match one list, so sources
+// downloading several have to use a checksumURL and signatures next to each
+// list instead.
+func ValidateVerify(config models.Source, options SourceOptions) error {
+	if config.Verify == (models.Verify{}) {
+		return nil
+	}
+	src, err := NewSource(config, options)
+	if err != nil {
+		return err
+	}
+	var urls []string
+	if urlSource, ok := src.(URLSource); ok {
+		urls = urlSource.URLs()
+	}
+	if len(urls) == 0 {
+		return fmt.Errorf("verify: source %s downloads no lists to verify", src.Name())
+	}
+	if len(urls) > 1 && (config.Verify.SHA256 != "" || config.Verify.SignatureURL != "") {
+		return fmt.Errorf("verify: sha256 and signatureURL apply to a single list, but the source fetches %d; "+
+			"use checksumURL and the default signature urls instead", len(urls))
+	}
+	return nil
+}
+
+// findChecksum looks up the digest of name in a sha256sum (`digest  name`)
+// or BSD (`SHA256 (name) = digest`) style file. A file with a single bare
+// digest applies to any name.
+func findChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	var digests []string
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "SHA256 (") {
+			fileName, digest, found := strings.Cut(strings.TrimPrefix(line, "SHA256 ("), ") = ")
+			if found && strings.TrimPrefix(fileName, "./") == name {
+				return digest, nil
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 1 {
+			digests = append(digests, fields[0])
+			continue
+		}
+		if strings.TrimPrefix(strings.TrimPrefix(fields[1], "*"), "./") == name {
+			return fields[0], nil
+		}
+	}
+	if len(digests) == 1 {
+		return digests[0], nil
+	}
+	return "", errors.New("checksum file has no entry for " + name)
+}
+
+// decodeMinisignLine decodes the first base64 line that isn't a comment
+func decodeMinisignLine(lines []string) ([]byte, []string, error) {
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(line)
+		return decoded, lines[i+1:], err
+	}
+	return nil, nil, errors.New("no base64 data")
+}
+
+// verifyMinisign checks a minisign signature, both legacy (Ed) and
+// prehashed (ED), including the signature of its trusted comment
+func verifyMinisign(body []byte, signature []byte, publicKeyPath string) error {
+	keyFile, err := os.ReadFile(publicKeyPath)
+	if err != nil {
+		return err
+	}
+	key, _, err := decodeMinisignLine(strings.Split(string(keyFile), "\n"))
+	if err != nil || len(key) != 42 || string(key[:2]) != "Ed" {
+		return errors.New("invalid minisign public key " + publicKeyPath)
+	}
+	keyID, publicKey := key[2:10], ed25519.PublicKey(key[10:])
+
+	sig, rest, err := decodeMinisignLine(strings.Split(string(signature), "\n"))
+	if err != nil || len(sig) != 74 {
+		return errors.New("invalid minisign signature")
+	}
+	if !bytes.Equal(sig[2:10], keyID) {
+		return errors.New("minisign signature was made with another key")
+	}
+	message := body
+	switch string(sig[:2]) {
+	case "Ed":
+	case "ED":
+		hash := blake2b.Sum512(body)
+		message = hash[:]
+	default:
+		return errors.New("unknown minisign signature algorithm")
+	}
+	if !ed25519.Verify(publicKey, message, sig[10:]) {
+		return errors.New("invalid minisign signature")
+	}
+
+	if len(rest) < 2 || !strings.HasPrefix(rest[0], "trusted comment: ") {
+		return errors.New("minisign signature has no trusted comment")
+	}
+	trustedComment := strings.TrimPrefix(strings.TrimRight(rest[0], "\r"), "trusted comment: ")
+	globalSig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(rest[1]))
+	if err != nil || !ed25519.Verify(publicKey, append(append([]byte{}, sig[10:]...), trustedComment...), globalSig) {
+		return errors.New("invalid minisign trusted comment signature")
+	}
+	return nil
+}
+
+// verifyPGP checks an armored or binary OpenPGP detached signature
+func verifyPGP(body []byte, signature []byte, publicKeyPath string) error {
+	keyFile, err := os.ReadFile(publicKeyPath)
+	if err != nil {
+		return err
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(keyFile))
+	if err != nil {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(keyFile))
+	}
+	if err != nil {
+		return fmt.Errorf("reading OpenPGP key %s: %w", publicKeyPath, err)
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(signature), []byte("-----BEGIN")) {
+		_, err = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(body), bytes.NewReader(signature), nil)
+	} else {
+		_, err = openpgp.CheckDetachedSignature(keyring, bytes.NewReader(body), bytes.NewReader(signature), nil)
+	}
+	return err
+}
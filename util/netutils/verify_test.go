@@ -0,0 +1,101 @@
+package netutils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+)
+
+func TestFindChecksum(t *testing.T) {
+	sha256sum := "# lists\n" +
+		"aaa  drop.txt\n" +
+		"bbb *edrop.txt\n" +
+		"ccc  ./ipv4/ir.cidr\n" +
+		"ddd  ipv6/ir.cidr\n"
+	bsd := "SHA256 (drop.txt) = eee\nSHA256 (ipv6/ir.cidr) = fff\n"
+	tests := []struct {
+		checksums string
+		name      string
+		want      string
+	}{
+		{sha256sum, "drop.txt", "aaa"},
+		{sha256sum, "edrop.txt", "bbb"},
+		{sha256sum, "ipv4/ir.cidr", "ccc"},
+		{sha256sum, "ipv6/ir.cidr", "ddd"},
+		{sha256sum, "ir.cidr", ""},
+		{sha256sum, "missing.txt", ""},
+		{bsd, "drop.txt", "eee"},
+		{bsd, "ipv6/ir.cidr", "fff"},
+		{bsd, "edrop.txt", ""},
+		{"ggg\n", "anything.txt", "ggg"},
+		{"ggg\nhhh\n", "anything.txt", ""},
+		{"", "drop.txt", ""},
+	}
+	for _, test := range tests {
+		got, err := findChecksum([]byte(test.checksums), test.name)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("findChecksum(%q, %s) = %s, want an error", test.checksums, test.name, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("findChecksum(%q, %s) = %s, %v, want %s", test.checksums, test.name, got, err, test.want)
+		}
+	}
+}
+
+func TestChecksumNames(t *testing.T) {
+	tests := []struct {
+		url         string
+		checksumURL string
+		want        string
+	}{
+		{"https://example.com/lists/drop.txt", "https://example.com/lists/SHA256SUMS", "drop.txt"},
+		{"https://example.com/ipv4/ir.cidr", "https://example.com/SHA256SUMS", "ipv4/ir.cidr ir.cidr"},
+		{"https://example.com/lists/drop.txt", "https://example.org/SHA256SUMS", "drop.txt"},
+		{"https://example.com/lists/drop.txt", "SHA256SUMS", "drop.txt"},
+	}
+	for _, test := range tests {
+		if got := strings.Join(checksumNames(test.url, test.checksumURL), " "); got != test.want {
+			t.Errorf("checksumNames(%s, %s) = %s, want %s", test.url, test.checksumURL, got, test.want)
+		}
+	}
+}
+
+func TestValidateVerify(t *testing.T) {
+	digest := models.Verify{SHA256: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"}
+	signature := models.Verify{Signature: "minisign", SignatureURL: "https://example.com/list.minisig", PublicKey: "lists.pub"}
+	checksum := models.Verify{ChecksumURL: "https://example.com/SHA256SUMS"}
+	database := SourceOptions{GeoDatabase: models.GeoDatabase{Path: "GeoLite2-Country.mmdb"}}
+	tests := []struct {
+		source  models.Source
+		options SourceOptions
+		ok      bool
+	}{
+		{models.Source{Type: "url", URL: []string{"https://example.com/a"}, Verify: digest}, SourceOptions{}, true},
+		{models.Source{Type: "url", URL: []string{"https://example.com/a", "https://example.com/b"}, Verify: digest}, SourceOptions{}, false},
+		{models.Source{Type: "url", URL: []string{"https://example.com/a", "https://example.com/b"}, Verify: signature}, SourceOptions{}, false},
+		{models.Source{Type: "url", URL: []string{"https://example.com/a", "https://example.com/b"}, Verify: checksum}, SourceOptions{}, true},
+		{models.Source{Type: "country", Country: "ir", Verify: digest}, SourceOptions{}, false},
+		{models.Source{Type: "country", Country: "ir", Verify: checksum}, SourceOptions{}, true},
+		{models.Source{Type: "country", Country: "ir", Verify: digest}, database, false},
+		{models.Source{Type: "country", Country: "ir", Verify: checksum}, database, false},
+		{models.Source{Type: "tor", Verify: digest}, SourceOptions{}, true},
+		{models.Source{Type: "cloud", Provider: "aws", Verify: digest}, SourceOptions{}, true},
+		{models.Source{Type: "cloud", Provider: "cloudflare", Verify: digest}, SourceOptions{}, false},
+		{models.Source{Type: "cloud", Provider: "cloudflare", Verify: checksum}, SourceOptions{}, true},
+		{models.Source{Type: "file", Path: []string{"/etc/ipsetfw/drop.txt"}, Verify: checksum}, SourceOptions{}, false},
+		{models.Source{Type: "dns", Hosts: []string{"example.com"}, Verify: checksum}, SourceOptions{}, false},
+		{models.Source{Type: "asn", ASN: []string{"AS64496"}, Verify: checksum}, SourceOptions{}, false},
+		{models.Source{Type: "url", URL: []string{"https://example.com/a"}}, SourceOptions{}, true},
+		{models.Source{Type: "file", Path: []string{"/etc/ipsetfw/drop.txt"}}, SourceOptions{}, true},
+	}
+	for _, test := range tests {
+		err := ValidateVerify(test.source, test.options)
+		if (err == nil) != test.ok {
+			t.Errorf("ValidateVerify(%+v) = %v, want ok %v", test.source, err, test.ok)
+		}
+	}
+}
@@ -1,25 +1,27 @@
 package notif
 
 import (
-	"bytes"
 	"encoding/json"
-	"net/http"
+	"fmt"
 
-	"github.com/sabershahhoseini/ipset-firewall/error/checkerr"
+	"github.com/sabershahhoseini/ipset-firewall/util/httpclient"
 )
 
-func SendNotificationMattermost(message, mattermostUrl, mattermostToken string) {
+func SendNotificationMattermost(client *httpclient.Client, message, mattermostUrl, mattermostToken string) error {
 	if mattermostToken == "" || mattermostUrl == "" {
-		return
+		return nil
 	}
 	postBody, _ := json.Marshal(map[string]string{
 		"text": message,
 	})
-	responseBody := bytes.NewBuffer(postBody)
 	url := mattermostUrl + "/hooks/" + mattermostToken
-	resp, err := http.Post(url, "application/json", responseBody)
+	resp, err := client.Post(url, "application/json", postBody)
 	if err != nil {
-		checkerr.Fatal(err)
+		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("mattermost: unexpected status %d", resp.StatusCode)
+	}
+	return nil
 }
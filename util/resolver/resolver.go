@@ -0,0 +1,226 @@
+// Package resolver is a small DNS stub resolver that, unlike net.Resolver,
+// returns the TTL of the records it resolves.
+package resolver
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sabershahhoseini/ipset-firewall/models"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	DefaultTimeout = 5 * time.Second
+	// fallbackServer is used when resolv.conf has no nameserver
+	fallbackServer = "127.0.0.1:53"
+)
+
+// Record is an address a host resolved to
+type Record struct {
+	Addr netip.Addr
+	// TTL is how many seconds the record may be cached
+	TTL uint32
+}
+
+type Resolver struct {
+	server  string
+	timeout time.Duration
+}
+
+// defaultResolver is used by a nil *Resolver
+var defaultResolver = New(models.DNS{})
+
+// New builds a resolver from the dns block of the config
+func New(config models.DNS) *Resolver {
+	server := config.Server
+	if server == "" {
+		server = systemServer()
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return &Resolver{server: server, timeout: timeout}
+}
+
+// systemServer returns the first nameserver of /etc/resolv.conf
+func systemServer() string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return fallbackServer
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53")
+		}
+	}
+	return fallbackServer
+}
+
+// Server returns the host:port queries are sent to
+func (r *Resolver) Server() string {
+	if r == nil {
+		r = defaultResolver
+	}
+	return r.server
+}
+
+// Lookup resolves the A and AAAA records of host. IP literals are returned
+// as is with a TTL of 0.
+func (r *Resolver) Lookup(host string) ([]Record, error) {
+	if r == nil {
+		r = defaultResolver
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []Record{{Addr: addr.Unmap()}}, nil
+	}
+	if !strings.HasSuffix(host, ".") {
+		host += "."
+	}
+	name, err := dnsmessage.NewName(host)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", host, err)
+	}
+
+	records, err := r.query(name, dnsmessage.TypeA)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", host, err)
+	}
+	records6, err := r.query(name, dnsmessage.TypeAAAA)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", host, err)
+	}
+	records = append(records, records6...)
+	if len(records) == 0 {
+		return nil, errors.New("resolving " + host + ": no addresses found")
+	}
+	return records, nil
+}
+
+func (r *Resolver) query(name dnsmessage.Name, qtype dnsmessage.Type) ([]Record, error) {
+	// The id is random so off-path attackers can't guess it to spoof answers
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	request := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:]), RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: qtype, Class: dnsmessage.ClassINET},
+		},
+	}
+	packed, err := request.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := r.exchange("udp", request, packed)
+	// Large answers don't fit in a udp response and are retried over tcp
+	if err == nil && response.Header.Truncated {
+		response, err = r.exchange("tcp", request, packed)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch response.Header.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, errors.New("no such host")
+	default:
+		return nil, errors.New("server " + r.server + " answered " + response.Header.RCode.String())
+	}
+
+	var records []Record
+	for _, answer := range response.Answers {
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			records = append(records, Record{Addr: netip.AddrFrom4(body.A), TTL: answer.Header.TTL})
+		case *dnsmessage.AAAAResource:
+			records = append(records, Record{Addr: netip.AddrFrom16(body.AAAA), TTL: answer.Header.TTL})
+		}
+	}
+	return records, nil
+}
+
+// answers reports whether response is the answer to request, with the same
+// id and question
+func answers(request dnsmessage.Message, response dnsmessage.Message) bool {
+	if !response.Header.Response || response.Header.ID != request.Header.ID || len(response.Questions) != 1 {
+		return false
+	}
+	question, asked := response.Questions[0], request.Questions[0]
+	return question.Type == asked.Type && question.Class == asked.Class &&
+		strings.EqualFold(question.Name.String(), asked.Name.String())
+}
+
+// exchange sends a packed request and returns the response answering it.
+// Over udp, other responses are skipped as stray or spoofed.
+func (r *Resolver) exchange(network string, request dnsmessage.Message, packed []byte) (dnsmessage.Message, error) {
+	var response dnsmessage.Message
+	conn, err := net.DialTimeout(network, r.server, r.timeout)
+	if err != nil {
+		return response, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(r.timeout))
+
+	if network == "tcp" {
+		length := make([]byte, 2)
+		binary.BigEndian.PutUint16(length, uint16(len(packed)))
+		if _, err := conn.Write(append(length, packed...)); err != nil {
+			return response, err
+		}
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return response, err
+		}
+		body := make([]byte, binary.BigEndian.Uint16(length))
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return response, err
+		}
+		if err := response.Unpack(body); err != nil {
+			return response, err
+		}
+		if !answers(request, response) {
+			return response, errors.New("server " + r.server + " answered a different question")
+		}
+		return response, nil
+	}
+
+	if _, err := conn.Write(packed); err != nil {
+		return response, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return response, err
+		}
+		// Skip stray responses to earlier queries
+		if n < 2 || binary.BigEndian.Uint16(buf) != request.Header.ID {
+			continue
+		}
+		if err := response.Unpack(buf[:n]); err != nil {
+			return response, err
+		}
+		if answers(request, response) {
+			return response, nil
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package resolver
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// serveDNS answers every query on a local udp server with the responses
+// reply builds, in order
+func serveDNS(t *testing.T, reply func(query dnsmessage.Message) []dnsmessage.Message) *Resolver {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if err := query.Unpack(buf[:n]); err != nil {
+				continue
+			}
+			for _, response := range reply(query) {
+				packed, err := response.Pack()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				conn.WriteTo(packed, addr)
+			}
+		}
+	}()
+	return &Resolver{server: conn.LocalAddr().String(), timeout: 500 * time.Millisecond}
+}
+
+// answer builds the response to query with question and an A record of addr
+func answer(query dnsmessage.Message, question dnsmessage.Question, addr string) dnsmessage.Message {
+	response := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: query.Header.ID, Response: true},
+		Questions: []dnsmessage.Question{question},
+	}
+	if question.Type == dnsmessage.TypeA {
+		response.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 300},
+			Body:   &dnsmessage.AResource{A: netip.MustParseAddr(addr).As4()},
+		}}
+	}
+	return response
+}
+
+func TestLookup(t *testing.T) {
+	r := serveDNS(t, func(query dnsmessage.Message) []dnsmessage.Message {
+		question := query.Questions[0]
+		// Answers to another name, type or id are ignored
+		other := question
+		other.Name = dnsmessage.MustNewName("attacker.example.")
+		otherType := question
+		otherType.Type = dnsmessage.TypeMX
+		otherID := answer(query, question, "198.51.100.66")
+		otherID.Header.ID++
+		return []dnsmessage.Message{
+			answer(query, other, "198.51.100.66"),
+			answer(query, otherType, "198.51.100.66"),
+			otherID,
+			answer(query, question, "192.0.2.1"),
+		}
+	})
+	records, err := r.Lookup("Example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Addr != netip.MustParseAddr("192.0.2.1") || records[0].TTL != 300 {
+		t.Errorf("Lookup(example.com) = %v, want [{192.0.2.1 300}]", records)
+	}
+}
+
+func TestLookupMismatchedAnswer(t *testing.T) {
+	r := serveDNS(t, func(query dnsmessage.Message) []dnsmessage.Message {
+		question := query.Questions[0]
+		question.Name = dnsmessage.MustNewName("attacker.example.")
+		return []dnsmessage.Message{answer(query, question, "198.51.100.66")}
+	})
+	if records, err := r.Lookup("example.com"); err == nil {
+		t.Errorf("Lookup(example.com) = %v, want an error", records)
+	}
+}
+
+func TestLookupLiteral(t *testing.T) {
+	records, err := (&Resolver{}).Lookup("::ffff:192.0.2.1")
+	if err != nil || len(records) != 1 || records[0].Addr != netip.MustParseAddr("192.0.2.1") {
+		t.Errorf("Lookup(::ffff:192.0.2.1) = %v, %v, want [{192.0.2.1 0}]", records, err)
+	}
+}